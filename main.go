@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"forum/utils"
 
@@ -10,7 +18,14 @@ import (
 )
 
 func main() {
-	_, err := utils.DBInitialize("forum")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := utils.DBInitialize("forum")
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -19,17 +34,117 @@ func main() {
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	http.HandleFunc("/", utils.DefaultHandler)
-	http.HandleFunc("/home", utils.HomeHandler)
+	http.HandleFunc("/home", utils.RequireGuestOrUser(utils.HomeHandler))
 	http.HandleFunc("/login", utils.LoginHandler)
 	http.HandleFunc("/logout", utils.LogoutHandler)
 	http.HandleFunc("/guest", utils.GuestHandler)
 	http.HandleFunc("/register", utils.RegisterHandler)
-	http.HandleFunc("/create-post", utils.CreatePostHandler)
+	http.HandleFunc("/verify", utils.VerifyHandler)
+	http.HandleFunc("/verify/resend", utils.RequireGuestOrUser(utils.ResendVerificationHandler))
+	http.HandleFunc("/forgot-password", utils.ForgotPasswordHandler)
+	http.HandleFunc("/reset-password", utils.ResetPasswordHandler)
+	http.HandleFunc("/settings", utils.RequireGuestOrUser(utils.SettingsHandler))
+	http.HandleFunc("/settings/export", utils.RequireGuestOrUser(utils.ExportHandler))
+	http.HandleFunc("/settings/delete", utils.RequireGuestOrUser(utils.DeleteAccountHandler))
+	http.HandleFunc("/create-post", utils.RequireRegistered(utils.CreatePostHandler))
 	http.HandleFunc("/post/", utils.PostHandler)
-	http.HandleFunc("/like", utils.LikeHandler)
-	http.HandleFunc("/dislike", utils.DislikeHandler)
+	http.HandleFunc("/like", utils.RequireRegistered(utils.LikeHandler))
+	http.HandleFunc("/dislike", utils.RequireRegistered(utils.DislikeHandler))
+	http.HandleFunc("/comment/like", utils.RequireRegistered(utils.CommentLikeHandler))
+	http.HandleFunc("/comment/dislike", utils.RequireRegistered(utils.CommentDislikeHandler))
 	http.HandleFunc("/filter", utils.FilterHandler)
+	http.HandleFunc("/search", utils.SearchHandler)
+	http.HandleFunc("/create-forum", utils.RequireRegistered(utils.CreateSubForumHandler))
+	http.HandleFunc("/f/", utils.SubForumHandler)
+	http.HandleFunc(utils.APIPrefix, utils.APIHandler)
+
+	// Stop on Ctrl+C or SIGTERM, letting requests and the janitor finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	janitorDone := db.StartJanitor(ctx, utils.JanitorInterval)
+
+	server := &http.Server{
+		Addr: ":8080",
+		// Authenticate resolves the session once and puts the user in the request context;
+		// CSRFProtect then checks the session's token on every state-changing request
+		Handler: utils.Authenticate(utils.CSRFProtect(http.DefaultServeMux)),
+	}
+	go func() {
+		log.Println("Server running on http://localhost:8080")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down server:", err)
+	}
+	<-janitorDone
+	if err := db.Conn.Close(); err != nil {
+		log.Println("Error closing database:", err)
+	}
+}
 
-	log.Println("Server running on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// migrate implements the `forum migrate` subcommand:
+//
+//	forum migrate [up]        apply all pending migrations
+//	forum migrate down [N]    roll back the last N migrations (default 1)
+//	forum migrate version     print the current schema version
+func migrate(args []string) error {
+	db, err := utils.DBOpen("forum")
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Conn.Close()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		applied, err := db.Migrate(utils.MigrationsDir)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		rolledBack, err := db.Rollback(utils.MigrationsDir, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "version":
+		version, err := db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Println(version)
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down [N] or version)", cmd)
+	}
+	return nil
 }
@@ -0,0 +1,6 @@
+drop table if exists post_categories;
+drop table if exists categories;
+drop table if exists interactions;
+drop table if exists comments;
+drop table if exists posts;
+drop table if exists users;
@@ -1,4 +1,3 @@
-PRAGMA foreign_keys = ON;
 -- users
 create table if not exists users (
     uuid text not null primary key unique,
@@ -54,4 +53,3 @@ create table if not exists post_categories (
     foreign key (post_id) references posts(id),
     foreign key (category_id) references categories(id)
 );
-
@@ -0,0 +1,2 @@
+drop table if exists comment_interactions;
+drop index if exists idx_interactions_user_post;
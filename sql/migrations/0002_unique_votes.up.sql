@@ -0,0 +1,17 @@
+-- one vote per user per post: drop duplicates left by older versions, then enforce it
+delete from interactions where id not in (
+    select max(id) from interactions group by user_uuid, post_id
+);
+create unique index if not exists idx_interactions_user_post on interactions(user_uuid, post_id);
+
+-- comment_interactions (one vote per user per comment)
+create table if not exists comment_interactions (
+    id integer primary key autoincrement,
+    user_uuid text not null,
+    comment_id integer not null,
+    liked boolean not null default 0,
+    disliked boolean not null default 0,
+    unique(user_uuid, comment_id),
+    foreign key(user_uuid) references users(uuid),
+    foreign key(comment_id) references comments(id)
+);
@@ -0,0 +1,12 @@
+-- replies (threaded under a comment, parent_id is null for direct replies)
+create table if not exists replies (
+    id integer primary key autoincrement,
+    content text not null,
+    reply_author_uuid text not null,
+    comment_id integer not null,
+    parent_id integer,
+    depth integer not null default 1,
+    foreign key(reply_author_uuid) references users(uuid),
+    foreign key(comment_id) references comments(id),
+    foreign key(parent_id) references replies(id)
+);
@@ -0,0 +1,3 @@
+drop table if exists subforum_posts;
+drop table if exists subforum_admins;
+drop table if exists subforums;
@@ -0,0 +1,25 @@
+-- subforums
+create table if not exists subforums (
+    id integer primary key autoincrement,
+    name text not null unique,
+    creator_uuid text not null,
+    foreign key(creator_uuid) references users(uuid)
+);
+
+-- subforum_admins (users who may pin or remove posts in a sub-forum)
+create table if not exists subforum_admins (
+    subforum_id integer not null,
+    user_uuid text not null,
+    primary key (subforum_id, user_uuid),
+    foreign key (subforum_id) references subforums(id),
+    foreign key (user_uuid) references users(uuid)
+);
+
+-- subforum_posts (a post belongs to at most one sub-forum)
+create table if not exists subforum_posts (
+    post_id integer not null primary key,
+    subforum_id integer not null,
+    pinned boolean not null default 0,
+    foreign key (post_id) references posts(id),
+    foreign key (subforum_id) references subforums(id)
+);
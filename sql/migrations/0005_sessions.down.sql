@@ -0,0 +1,3 @@
+alter table users add column loggedin boolean not null default 0;
+drop index if exists idx_sessions_user;
+drop table if exists sessions;
@@ -0,0 +1,16 @@
+-- sessions (the cookie holds a random token; only its SHA-256 is stored)
+create table if not exists sessions (
+    id integer primary key autoincrement,
+    token_hash text not null unique,
+    user_uuid text not null,
+    created_at text not null,
+    expires_at text not null,
+    ip text not null,
+    user_agent text not null,
+    revoked_at text,
+    foreign key(user_uuid) references users(uuid)
+);
+create index if not exists idx_sessions_user on sessions(user_uuid);
+
+-- login state now lives in sessions
+alter table users drop column loggedin;
@@ -0,0 +1,3 @@
+drop index if exists idx_session_events_user;
+drop table if exists session_events;
+alter table sessions drop column revoked_reason;
@@ -0,0 +1,16 @@
+-- why a session was revoked, e.g. "logout" or "replaced"
+alter table sessions add column revoked_reason text;
+
+-- session_events (audit trail, e.g. a login that signed out older sessions)
+create table if not exists session_events (
+    id integer primary key autoincrement,
+    user_uuid text not null,
+    session_id integer,
+    event text not null,
+    ip text not null,
+    user_agent text not null,
+    created_at text not null,
+    foreign key(user_uuid) references users(uuid),
+    foreign key(session_id) references sessions(id)
+);
+create index if not exists idx_session_events_user on session_events(user_uuid);
@@ -0,0 +1,3 @@
+-- per-session CSRF token echoed back by every form
+alter table sessions add column csrf_token text not null default '';
+update sessions set csrf_token = lower(hex(randomblob(32))) where csrf_token = '';
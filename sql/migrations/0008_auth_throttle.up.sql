@@ -0,0 +1,7 @@
+-- auth_throttle (failed logins/sign-ups per IP or account, for backoff and lockout)
+create table if not exists auth_throttle (
+    key text not null primary key,
+    failures integer not null default 0,
+    last_failure text not null,
+    locked_until text
+);
@@ -0,0 +1,2 @@
+drop index if exists idx_users_email_ci;
+drop index if exists idx_users_username_ci;
@@ -0,0 +1,4 @@
+-- usernames and emails are unique regardless of case and surrounding spaces;
+-- guests have no email, so empty ones are left out
+create unique index if not exists idx_users_username_ci on users(lower(trim(username)));
+create unique index if not exists idx_users_email_ci on users(lower(trim(email))) where trim(email) <> '';
@@ -0,0 +1,2 @@
+drop table if exists secrets;
+alter table users drop column emailverified;
@@ -0,0 +1,9 @@
+-- accounts must confirm their email; existing members are trusted as-is
+alter table users add column emailverified boolean not null default 0;
+update users set emailverified = 1 where notregistered = 0;
+
+-- secrets (server-side keys, e.g. for signing verification links)
+create table if not exists secrets (
+    name text not null primary key,
+    value text not null
+);
@@ -0,0 +1,2 @@
+drop index if exists idx_password_resets_user;
+drop table if exists password_resets;
@@ -0,0 +1,12 @@
+-- password_resets (single-use reset links; only the token's SHA-256 is stored)
+create table if not exists password_resets (
+    id integer primary key autoincrement,
+    user_uuid text not null,
+    token_hash text not null unique,
+    created_at text not null,
+    expires_at text not null,
+    used_at text,
+    ip text not null,
+    foreign key(user_uuid) references users(uuid)
+);
+create index if not exists idx_password_resets_user on password_resets(user_uuid);
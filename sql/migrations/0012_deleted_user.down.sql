@@ -0,0 +1 @@
+delete from users where uuid = '00000000-0000-0000-0000-000000000000';
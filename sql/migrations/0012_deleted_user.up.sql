@@ -0,0 +1,4 @@
+-- the tombstone account that posts and comments of deleted accounts are
+-- reassigned to; it has no password or email, so nobody can log in as it
+insert or ignore into users (uuid, username, email, password, notregistered, lastseen, emailverified)
+values ('00000000-0000-0000-0000-000000000000', 'deleted', '', '', 0, '1970-01-01T00:00:00Z', 0);
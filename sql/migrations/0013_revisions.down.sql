@@ -0,0 +1,6 @@
+drop index if exists idx_revisions_target;
+drop table if exists revisions;
+alter table comments drop column deleted_at;
+alter table comments drop column edited_at;
+alter table posts drop column deleted_at;
+alter table posts drop column edited_at;
@@ -0,0 +1,19 @@
+-- posts and comments can be edited and soft-deleted by their authors
+alter table posts add column edited_at text;
+alter table posts add column deleted_at text;
+alter table comments add column edited_at text;
+alter table comments add column deleted_at text;
+
+-- revisions (the version of a post or comment before each edit or deletion)
+create table if not exists revisions (
+    id integer primary key autoincrement,
+    kind text not null check (kind in ('post', 'comment')),
+    target_id integer not null,
+    title text,
+    content text not null,
+    editor_uuid text not null,
+    reason text not null check (reason in ('edit', 'delete')),
+    created_at text not null,
+    foreign key(editor_uuid) references users(uuid)
+);
+create index if not exists idx_revisions_target on revisions(kind, target_id);
@@ -0,0 +1,12 @@
+drop index if exists idx_comments_post_created;
+drop index if exists idx_posts_created;
+alter table comment_interactions drop column updated_at;
+alter table comment_interactions drop column created_at;
+alter table interactions drop column updated_at;
+alter table interactions drop column created_at;
+alter table replies drop column updated_at;
+alter table replies drop column created_at;
+alter table comments drop column updated_at;
+alter table comments drop column created_at;
+alter table posts drop column updated_at;
+alter table posts drop column created_at;
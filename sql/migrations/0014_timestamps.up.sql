@@ -0,0 +1,21 @@
+-- creation and last-update times (UTC, RFC 3339) on posts, comments, replies and votes.
+-- Rows from before this migration get the time it ran; their ids keep the order.
+alter table posts add column created_at text not null default '';
+alter table posts add column updated_at text not null default '';
+alter table comments add column created_at text not null default '';
+alter table comments add column updated_at text not null default '';
+alter table replies add column created_at text not null default '';
+alter table replies add column updated_at text not null default '';
+alter table interactions add column created_at text not null default '';
+alter table interactions add column updated_at text not null default '';
+alter table comment_interactions add column created_at text not null default '';
+alter table comment_interactions add column updated_at text not null default '';
+
+update posts set created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+update comments set created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+update replies set created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+update interactions set created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+update comment_interactions set created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+
+create index if not exists idx_posts_created on posts(created_at);
+create index if not exists idx_comments_post_created on comments(post_id, created_at);
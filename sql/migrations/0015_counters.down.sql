@@ -0,0 +1,14 @@
+drop trigger if exists comment_interactions_count_update;
+drop trigger if exists comment_interactions_count_delete;
+drop trigger if exists comment_interactions_count_insert;
+drop trigger if exists interactions_count_update;
+drop trigger if exists interactions_count_delete;
+drop trigger if exists interactions_count_insert;
+drop trigger if exists comments_count_update;
+drop trigger if exists comments_count_delete;
+drop trigger if exists comments_count_insert;
+alter table comments drop column dislike_count;
+alter table comments drop column like_count;
+alter table posts drop column dislike_count;
+alter table posts drop column like_count;
+alter table posts drop column comment_count;
@@ -0,0 +1,55 @@
+-- vote and comment counters, kept in step by triggers so listings don't count rows per post
+alter table posts add column comment_count integer not null default 0;
+alter table posts add column like_count integer not null default 0;
+alter table posts add column dislike_count integer not null default 0;
+alter table comments add column like_count integer not null default 0;
+alter table comments add column dislike_count integer not null default 0;
+
+update posts set
+    comment_count = (select count(*) from comments where comments.post_id = posts.id),
+    like_count = (select count(*) from interactions where interactions.post_id = posts.id and liked = 1),
+    dislike_count = (select count(*) from interactions where interactions.post_id = posts.id and disliked = 1);
+update comments set
+    like_count = (select count(*) from comment_interactions where comment_id = comments.id and liked = 1),
+    dislike_count = (select count(*) from comment_interactions where comment_id = comments.id and disliked = 1);
+
+create trigger if not exists comments_count_insert after insert on comments begin
+    update posts set comment_count = comment_count + 1 where id = new.post_id;
+end;
+create trigger if not exists comments_count_delete after delete on comments begin
+    update posts set comment_count = comment_count - 1 where id = old.post_id;
+end;
+create trigger if not exists comments_count_update after update of post_id on comments begin
+    update posts set comment_count = comment_count - 1 where id = old.post_id;
+    update posts set comment_count = comment_count + 1 where id = new.post_id;
+end;
+
+create trigger if not exists interactions_count_insert after insert on interactions begin
+    update posts set like_count = like_count + (new.liked = 1), dislike_count = dislike_count + (new.disliked = 1)
+    where id = new.post_id;
+end;
+create trigger if not exists interactions_count_delete after delete on interactions begin
+    update posts set like_count = like_count - (old.liked = 1), dislike_count = dislike_count - (old.disliked = 1)
+    where id = old.post_id;
+end;
+create trigger if not exists interactions_count_update after update of liked, disliked, post_id on interactions begin
+    update posts set like_count = like_count - (old.liked = 1), dislike_count = dislike_count - (old.disliked = 1)
+    where id = old.post_id;
+    update posts set like_count = like_count + (new.liked = 1), dislike_count = dislike_count + (new.disliked = 1)
+    where id = new.post_id;
+end;
+
+create trigger if not exists comment_interactions_count_insert after insert on comment_interactions begin
+    update comments set like_count = like_count + (new.liked = 1), dislike_count = dislike_count + (new.disliked = 1)
+    where id = new.comment_id;
+end;
+create trigger if not exists comment_interactions_count_delete after delete on comment_interactions begin
+    update comments set like_count = like_count - (old.liked = 1), dislike_count = dislike_count - (old.disliked = 1)
+    where id = old.comment_id;
+end;
+create trigger if not exists comment_interactions_count_update after update of liked, disliked, comment_id on comment_interactions begin
+    update comments set like_count = like_count - (old.liked = 1), dislike_count = dislike_count - (old.disliked = 1)
+    where id = old.comment_id;
+    update comments set like_count = like_count + (new.liked = 1), dislike_count = dislike_count + (new.disliked = 1)
+    where id = new.comment_id;
+end;
@@ -0,0 +1,7 @@
+drop trigger if exists search_comments_delete;
+drop trigger if exists search_comments_update;
+drop trigger if exists search_comments_insert;
+drop trigger if exists search_posts_delete;
+drop trigger if exists search_posts_update;
+drop trigger if exists search_posts_insert;
+drop table if exists search_index;
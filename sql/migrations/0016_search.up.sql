@@ -0,0 +1,36 @@
+-- full-text search over posts and comments; needs SQLite built with FTS5
+-- (go build -tags sqlite_fts5). Posts are stored under rowid id*2 and
+-- comments under id*2+1, so a change can find its row without a scan.
+-- Soft-deleted posts and comments leave the index. Replies are out of
+-- scope: they aren't indexed, so search never finds them.
+
+create virtual table search_index using fts5(title, content, tokenize = 'unicode61 remove_diacritics 2');
+
+create trigger search_posts_insert after insert on posts begin
+    insert into search_index (rowid, title, content) values (new.id * 2, new.title, new.content);
+end;
+create trigger search_posts_update after update of title, content, deleted_at on posts begin
+    delete from search_index where rowid = old.id * 2;
+    insert into search_index (rowid, title, content)
+    select new.id * 2, new.title, new.content where new.deleted_at is null;
+end;
+create trigger search_posts_delete after delete on posts begin
+    delete from search_index where rowid = old.id * 2;
+end;
+
+create trigger search_comments_insert after insert on comments begin
+    insert into search_index (rowid, title, content) values (new.id * 2 + 1, '', new.content);
+end;
+create trigger search_comments_update after update of content, deleted_at on comments begin
+    delete from search_index where rowid = old.id * 2 + 1;
+    insert into search_index (rowid, title, content)
+    select new.id * 2 + 1, '', new.content where new.deleted_at is null;
+end;
+create trigger search_comments_delete after delete on comments begin
+    delete from search_index where rowid = old.id * 2 + 1;
+end;
+
+insert into search_index (rowid, title, content)
+select id * 2, title, content from posts where deleted_at is null;
+insert into search_index (rowid, title, content)
+select id * 2 + 1, '', content from comments where deleted_at is null;
@@ -0,0 +1,10 @@
+drop trigger if exists replies_active_update;
+drop trigger if exists replies_active_delete;
+drop trigger if exists replies_active_insert;
+drop trigger if exists comments_active_update;
+drop trigger if exists comments_active_delete;
+drop trigger if exists comments_active_insert;
+drop trigger if exists posts_active_update;
+drop trigger if exists posts_active_insert;
+drop index if exists idx_posts_active;
+alter table posts drop column active_at;
@@ -0,0 +1,64 @@
+-- when a post last saw activity: its own creation or its newest comment or reply.
+-- Kept in step by triggers so the active sort doesn't scan comments and replies per post.
+alter table posts add column active_at text not null default '';
+
+update posts set active_at = max(created_at,
+    coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+    coalesce((select max(replies.created_at) from replies
+        join comments on replies.comment_id = comments.id
+        where comments.post_id = posts.id), ''));
+
+create index if not exists idx_posts_active on posts(active_at);
+
+create trigger if not exists posts_active_insert after insert on posts begin
+    update posts set active_at = max(new.created_at, new.active_at) where id = new.id;
+end;
+create trigger if not exists posts_active_update after update of created_at on posts begin
+    update posts set active_at = max(created_at,
+        coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+        coalesce((select max(replies.created_at) from replies
+            join comments on replies.comment_id = comments.id
+            where comments.post_id = posts.id), ''))
+    where id = new.id;
+end;
+
+create trigger if not exists comments_active_insert after insert on comments begin
+    update posts set active_at = max(active_at, new.created_at) where id = new.post_id;
+end;
+create trigger if not exists comments_active_delete after delete on comments begin
+    update posts set active_at = max(created_at,
+        coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+        coalesce((select max(replies.created_at) from replies
+            join comments on replies.comment_id = comments.id
+            where comments.post_id = posts.id), ''))
+    where id = old.post_id;
+end;
+create trigger if not exists comments_active_update after update of created_at, post_id on comments begin
+    update posts set active_at = max(created_at,
+        coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+        coalesce((select max(replies.created_at) from replies
+            join comments on replies.comment_id = comments.id
+            where comments.post_id = posts.id), ''))
+    where id in (old.post_id, new.post_id);
+end;
+
+create trigger if not exists replies_active_insert after insert on replies begin
+    update posts set active_at = max(active_at, new.created_at)
+    where id = (select post_id from comments where id = new.comment_id);
+end;
+create trigger if not exists replies_active_delete after delete on replies begin
+    update posts set active_at = max(created_at,
+        coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+        coalesce((select max(replies.created_at) from replies
+            join comments on replies.comment_id = comments.id
+            where comments.post_id = posts.id), ''))
+    where id = (select post_id from comments where id = old.comment_id);
+end;
+create trigger if not exists replies_active_update after update of created_at, comment_id on replies begin
+    update posts set active_at = max(created_at,
+        coalesce((select max(created_at) from comments where comments.post_id = posts.id), ''),
+        coalesce((select max(replies.created_at) from replies
+            join comments on replies.comment_id = comments.id
+            where comments.post_id = posts.id), ''))
+    where id in (select post_id from comments where id in (old.comment_id, new.comment_id));
+end;
@@ -2,10 +2,9 @@ package utils
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
-	"net/http"
-	"os"
+	"log"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
@@ -15,9 +14,14 @@ import (
 
 var db *DataBase
 
-// DBInitialize connects to SQLite
-func DBInitialize(dataSourceName string) (*DataBase, error) {
-	conn, err := sql.Open("sqlite3", "./"+dataSourceName+".db")
+// DBOpen connects to SQLite without touching the schema. Relative names
+// are taken from the working directory.
+func DBOpen(dataSourceName string) (*DataBase, error) {
+	path := dataSourceName + ".db"
+	if !filepath.IsAbs(path) {
+		path = "./" + path
+	}
+	conn, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
@@ -25,44 +29,28 @@ func DBInitialize(dataSourceName string) (*DataBase, error) {
 		return nil, err
 	}
 	db = &DataBase{Conn: conn}
-	// ✅ Ensure the users table exists
-	if err := db.ExecuteSQLFile("sql/tables.sql"); err != nil {
-		fmt.Println("Error initializing tables:", err)
-	}
+	store = db
 	return db, nil
 }
 
-// ExecuteSQLFile reads an SQL file and executes all statements in it.
-func (db *DataBase) ExecuteSQLFile(filepath string) error {
-	db.Write.Lock()
-	defer db.Write.Unlock()
-
-	// Read the SQL file using os.ReadFile (not ioutil)
-	sqlBytes, err := os.ReadFile(filepath)
+// DBInitialize connects to SQLite and applies any pending migrations. It
+// fails with ErrNoFTS5 if SQLite was built without FTS5, since search needs it.
+func DBInitialize(dataSourceName string) (*DataBase, error) {
+	db, err := DBOpen(dataSourceName)
 	if err != nil {
-		return fmt.Errorf("failed to read SQL file: %w", err)
+		return nil, err
 	}
-
-	// Convert to string
-	sqlContent := string(sqlBytes)
-
-	// Split statements by semicolon
-	statements := strings.Split(sqlContent, ";")
-
-	// Execute each statement
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
-		}
-
-		_, err := db.Conn.Exec(stmt)
-		if err != nil {
-			return fmt.Errorf("failed to execute statement: %w \nstmt: %s", err, stmt)
-		}
+	if err := checkFTS5(db.Conn); err != nil {
+		return nil, err
 	}
-
-	return nil
+	applied, err := db.Migrate(MigrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	return db, nil
 }
 
 // flattenStruct recursively flattens a struct into column names and values
@@ -130,41 +118,14 @@ func (db *DataBase) SafeWriter(table string, data interface{}) error {
 	return err
 }
 
-func (db *DataBase) CheckSession(w http.ResponseWriter, uuid string) error {
-	var lastseenStr string
-
-	// Query the lastseen timestamp for this UUID
-	err := db.Conn.QueryRow("SELECT lastseen FROM users WHERE uuid = ?", uuid).Scan(&lastseenStr)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("user not found")
-		}
-		return fmt.Errorf("database error: %w", err)
-	}
-
-	// Try parsing lastseen using RFC3339 format (e.g. "2025-08-26T22:08:38+03:00")
-	lastseen, err := time.Parse(time.RFC3339, lastseenStr)
+// parseTimestamp parses the timestamps stored in text columns.
+func parseTimestamp(value string) (time.Time, error) {
+	// Try parsing using RFC3339 format (e.g. "2025-08-26T22:08:38+03:00")
+	t, err := time.Parse(time.RFC3339, value)
 	if err != nil {
 		// If RFC3339 parsing fails, try the alternative layout with space separator
 		const layout = "2006-01-02 15:04:05.999999999Z07:00"
-		lastseen, err = time.Parse(layout, lastseenStr)
-		if err != nil {
-			return fmt.Errorf("invalid timestamp format in database: %w", err)
-		}
+		t, err = time.Parse(layout, value)
 	}
-
-	// Check if session has timed out
-if time.Since(lastseen) > SessionTimeout {
-    ClearUserCookie(w)
-    db.Conn.Exec("UPDATE users SET loggedin = 0 WHERE uuid = ?", uuid)
-    db.DeleteUser(uuid)
-    return errors.New("session timeout")
-}
-	return nil
-}
-
-func (db *DataBase) RefreshSession(uuid string) error {
-	query := "UPDATE users SET lastseen = ? WHERE uuid = ?"
-	_, err := db.Conn.Exec(query, time.Now().Format(time.RFC3339), uuid)
-	return err
+	return t, err
 }
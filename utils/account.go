@@ -0,0 +1,546 @@
+package utils
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DeletedUserUUID is the tombstone account (created by migration 0012) that
+// content of deleted accounts is reassigned to. It shows up as "deleted".
+const DeletedUserUUID = "00000000-0000-0000-0000-000000000000"
+
+// checkDeletedUsername stops migration 0012 when a real user is already
+// called "deleted", which the tombstone account needs as its name.
+func checkDeletedUsername(tx *sql.Tx) error {
+	var name, uuid string
+	err := tx.QueryRow("SELECT username, uuid FROM users WHERE lower(trim(username)) = 'deleted' AND uuid <> ?", DeletedUserUUID).Scan(&name, &uuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("user %s (%s) must be renamed before the username \"deleted\" can be given to the tombstone account", name, uuid)
+}
+
+// deletedContent replaces the text of replies that have to stay because others answered them.
+const deletedContent = "[deleted]"
+
+// What DeleteAccount does with the posts, comments and replies a user wrote.
+const (
+	DeleteAnonymize = "anonymize" // keep them, credited to the tombstone account
+	DeleteErase     = "erase"     // remove them; what others answered stays as "[deleted]"
+)
+
+// DeleteAccount removes a registered user. Their votes, sessions, failed
+// attempts counted against their username or email, and other personal rows
+// always go; mode decides what happens to what they wrote.
+// Sub-forums they created are kept for their members and handed to the tombstone.
+func (db *DataBase) DeleteAccount(uuid, mode string) error {
+	if uuid == DeletedUserUUID {
+		return errors.New("the tombstone account can't be deleted")
+	}
+	if mode != DeleteAnonymize && mode != DeleteErase {
+		return fmt.Errorf("unknown deletion mode %q", mode)
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user := User{UUID: uuid}
+	err = tx.QueryRow("SELECT username, email FROM users WHERE uuid = ? AND notregistered = 0", uuid).Scan(&user.Username, &user.Email)
+	if err != nil {
+		return err
+	}
+	for _, key := range userThrottleKeys(&user) {
+		if _, err := tx.Exec("DELETE FROM auth_throttle WHERE key = ?", key); err != nil {
+			return err
+		}
+	}
+
+	if mode == DeleteErase {
+		if err := eraseContentTx(tx, uuid); err != nil {
+			return err
+		}
+	}
+
+	// Children before parents, so no row is left pointing at the user
+	statements := []string{
+		"UPDATE posts SET author_uuid = ? WHERE author_uuid = ?",
+		"UPDATE comments SET comment_author_uuid = ? WHERE comment_author_uuid = ?",
+		"UPDATE replies SET reply_author_uuid = ? WHERE reply_author_uuid = ?",
+		"UPDATE subforums SET creator_uuid = ? WHERE creator_uuid = ?",
+		"UPDATE revisions SET editor_uuid = ? WHERE editor_uuid = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, DeletedUserUUID, uuid); err != nil {
+			return err
+		}
+	}
+	statements = []string{
+		"DELETE FROM interactions WHERE user_uuid = ?",
+		"DELETE FROM comment_interactions WHERE user_uuid = ?",
+		"DELETE FROM subforum_admins WHERE user_uuid = ?",
+		"DELETE FROM session_events WHERE user_uuid = ?",
+		"DELETE FROM sessions WHERE user_uuid = ?",
+		"DELETE FROM password_resets WHERE user_uuid = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, uuid); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM users WHERE uuid = ? AND notregistered = 0", uuid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// eraseContentTx deletes the user's posts with everything under them, and
+// their comments with their replies and history. Comments and replies that
+// others have answered keep their place in the thread as "[deleted]";
+// DeleteAccount then credits them to the tombstone.
+func eraseContentTx(tx *sql.Tx, uuid string) error {
+	postIDs, err := queryIDs(tx, "SELECT id FROM posts WHERE author_uuid = ?", uuid)
+	if err != nil {
+		return err
+	}
+	for _, id := range postIDs {
+		if err := deletePostTx(tx, id); err != nil {
+			return err
+		}
+	}
+
+	const unanswered = "comment_author_uuid = ?1 AND id NOT IN (SELECT comment_id FROM replies WHERE reply_author_uuid <> ?1)"
+	statements := []string{
+		"DELETE FROM revisions WHERE kind = 'comment' AND target_id IN (SELECT id FROM comments WHERE comment_author_uuid = ?1)",
+		"DELETE FROM replies WHERE comment_id IN (SELECT id FROM comments WHERE " + unanswered + ")",
+		"DELETE FROM comment_interactions WHERE comment_id IN (SELECT id FROM comments WHERE " + unanswered + ")",
+		"DELETE FROM comments WHERE " + unanswered,
+		"DELETE FROM replies WHERE reply_author_uuid = ?1 AND id NOT IN (SELECT parent_id FROM replies WHERE parent_id IS NOT NULL)",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, uuid); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE comments SET content = ?1, deleted_at = COALESCE(deleted_at, ?2), updated_at = ?2 WHERE comment_author_uuid = ?3",
+		deletedContent, sessionTime(time.Now()), uuid); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE replies SET content = ? WHERE reply_author_uuid = ?", deletedContent, uuid)
+	return err
+}
+
+// queryIDs collects the integer IDs a query returns.
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UserExport is everything the forum stores about one user.
+type UserExport struct {
+	ExportedAt   time.Time        `json:"exported_at"`
+	Profile      ExportProfile    `json:"profile"`
+	Posts        []ExportPost     `json:"posts"`
+	Comments     []ExportComment  `json:"comments"`
+	Replies      []ExportReply    `json:"replies"`
+	PostVotes    []ExportVote     `json:"post_votes"`
+	CommentVotes []ExportVote     `json:"comment_votes"`
+	SubForums    []ExportSubForum `json:"subforums"`
+	Sessions     []ExportSession  `json:"sessions"`
+	Revisions    []ExportRevision `json:"revisions"`
+}
+
+type ExportProfile struct {
+	UUID          string    `json:"uuid"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+type ExportPost struct {
+	ID         int      `json:"id"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Categories []string `json:"categories"`
+	SubForum   string   `json:"subforum,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+	UpdatedAt  string   `json:"updated_at"`
+}
+
+type ExportComment struct {
+	ID        int    `json:"id"`
+	PostID    int    `json:"post_id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ExportReply struct {
+	ID        int    `json:"id"`
+	CommentID int    `json:"comment_id"`
+	ParentID  int    `json:"parent_id,omitempty"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ExportVote is a like or dislike; ID is the post or comment voted on.
+type ExportVote struct {
+	ID        int    `json:"id"`
+	Vote      string `json:"vote"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ExportSubForum struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Creator bool   `json:"creator"`
+	Admin   bool   `json:"admin"`
+}
+
+// ExportRevision is an earlier version of one of the user's posts or comments.
+type ExportRevision struct {
+	Kind      string `json:"kind"`
+	TargetID  int    `json:"target_id"`
+	Title     string `json:"title,omitempty"`
+	Content   string `json:"content"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportSession struct {
+	CreatedAt     string `json:"created_at"`
+	ExpiresAt     string `json:"expires_at"`
+	IP            string `json:"ip"`
+	UserAgent     string `json:"user_agent"`
+	RevokedAt     string `json:"revoked_at,omitempty"`
+	RevokedReason string `json:"revoked_reason,omitempty"`
+}
+
+// ExportUserData gathers a user's profile, content with its earlier
+// versions, votes and sessions.
+func (db *DataBase) ExportUserData(uuid string) (*UserExport, error) {
+	user, err := db.UserByUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			UUID:          user.UUID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			LastSeen:      user.Lastseen,
+		},
+		Posts:        []ExportPost{},
+		Comments:     []ExportComment{},
+		Replies:      []ExportReply{},
+		PostVotes:    []ExportVote{},
+		CommentVotes: []ExportVote{},
+		SubForums:    []ExportSubForum{},
+		Sessions:     []ExportSession{},
+		Revisions:    []ExportRevision{},
+	}
+
+	err = db.eachRow(`
+        SELECT posts.id, posts.title, posts.content,
+            COALESCE((SELECT GROUP_CONCAT(categories.name, ',') FROM post_categories
+                JOIN categories ON categories.id = post_categories.category_id
+                WHERE post_categories.post_id = posts.id), ''),
+            COALESCE(subforums.name, ''), posts.created_at, posts.updated_at
+        FROM posts
+        LEFT JOIN subforum_posts ON subforum_posts.post_id = posts.id
+        LEFT JOIN subforums ON subforums.id = subforum_posts.subforum_id
+        WHERE posts.author_uuid = ? ORDER BY posts.id
+    `, uuid, func(rows *sql.Rows) error {
+		var p ExportPost
+		var categories string
+		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &categories, &p.SubForum, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return err
+		}
+		p.Categories = []string{}
+		if categories != "" {
+			p.Categories = strings.Split(categories, ",")
+		}
+		export.Posts = append(export.Posts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.eachRow("SELECT id, post_id, content, created_at, updated_at FROM comments WHERE comment_author_uuid = ? ORDER BY id", uuid, func(rows *sql.Rows) error {
+		var c ExportComment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return err
+		}
+		export.Comments = append(export.Comments, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.eachRow("SELECT id, comment_id, COALESCE(parent_id, 0), content, created_at FROM replies WHERE reply_author_uuid = ? ORDER BY id", uuid, func(rows *sql.Rows) error {
+		var r ExportReply
+		if err := rows.Scan(&r.ID, &r.CommentID, &r.ParentID, &r.Content, &r.CreatedAt); err != nil {
+			return err
+		}
+		export.Replies = append(export.Replies, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	votes := func(dst *[]ExportVote) func(rows *sql.Rows) error {
+		return func(rows *sql.Rows) error {
+			var v ExportVote
+			var liked, disliked bool
+			if err := rows.Scan(&v.ID, &liked, &disliked, &v.CreatedAt, &v.UpdatedAt); err != nil {
+				return err
+			}
+			switch {
+			case liked:
+				v.Vote = VoteLike.String()
+			case disliked:
+				v.Vote = VoteDislike.String()
+			default:
+				return nil
+			}
+			*dst = append(*dst, v)
+			return nil
+		}
+	}
+	if err := db.eachRow("SELECT post_id, liked, disliked, created_at, updated_at FROM interactions WHERE user_uuid = ? ORDER BY post_id", uuid, votes(&export.PostVotes)); err != nil {
+		return nil, err
+	}
+	if err := db.eachRow("SELECT comment_id, liked, disliked, created_at, updated_at FROM comment_interactions WHERE user_uuid = ? ORDER BY comment_id", uuid, votes(&export.CommentVotes)); err != nil {
+		return nil, err
+	}
+
+	err = db.eachRow(`
+        SELECT subforums.id, subforums.name, subforums.creator_uuid = ?1,
+            EXISTS (SELECT 1 FROM subforum_admins WHERE subforum_admins.subforum_id = subforums.id AND subforum_admins.user_uuid = ?1)
+        FROM subforums
+        WHERE subforums.creator_uuid = ?1
+           OR subforums.id IN (SELECT subforum_id FROM subforum_admins WHERE user_uuid = ?1)
+        ORDER BY subforums.id
+    `, uuid, func(rows *sql.Rows) error {
+		var f ExportSubForum
+		if err := rows.Scan(&f.ID, &f.Name, &f.Creator, &f.Admin); err != nil {
+			return err
+		}
+		export.SubForums = append(export.SubForums, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.eachRow(`
+        SELECT created_at, expires_at, ip, user_agent, COALESCE(revoked_at, ''), COALESCE(revoked_reason, '')
+        FROM sessions WHERE user_uuid = ? ORDER BY id
+    `, uuid, func(rows *sql.Rows) error {
+		var s ExportSession
+		if err := rows.Scan(&s.CreatedAt, &s.ExpiresAt, &s.IP, &s.UserAgent, &s.RevokedAt, &s.RevokedReason); err != nil {
+			return err
+		}
+		export.Sessions = append(export.Sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Whoever made the change, earlier versions of the user's own writing are theirs
+	err = db.eachRow(`
+        SELECT revisions.kind, revisions.target_id, COALESCE(revisions.title, ''), revisions.content,
+            revisions.reason, revisions.created_at
+        FROM revisions
+        LEFT JOIN posts ON revisions.kind = 'post' AND posts.id = revisions.target_id
+        LEFT JOIN comments ON revisions.kind = 'comment' AND comments.id = revisions.target_id
+        WHERE posts.author_uuid = ?1 OR comments.comment_author_uuid = ?1
+        ORDER BY revisions.id
+    `, uuid, func(rows *sql.Rows) error {
+		var r ExportRevision
+		if err := rows.Scan(&r.Kind, &r.TargetID, &r.Title, &r.Content, &r.Reason, &r.CreatedAt); err != nil {
+			return err
+		}
+		export.Revisions = append(export.Revisions, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// eachRow runs a query that takes one argument and calls fn for every row.
+func (db *DataBase) eachRow(query string, arg interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := db.Conn.Query(query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportHandler handles GET /settings/export; wrapped in RequireGuestOrUser.
+// It sends a ZIP with one JSON file per section, or a single JSON document
+// with ?format=json.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := CurrentUser(r)
+	if user.NotRegistered {
+		RenderError(w, "Guests have no account data to download", http.StatusForbidden)
+		return
+	}
+
+	export, err := store.ExportUserData(user.UUID)
+	if err != nil {
+		log.Println("Error exporting user data:", err)
+		RenderError(w, "Failed to export your data", http.StatusInternalServerError)
+		return
+	}
+
+	name := "forumhub-" + outboxSafe(user.Username) + "-" + export.ExportedAt.Format("20060102")
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			log.Println("Error writing data export:", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	if err := writeExportZip(w, name, export); err != nil {
+		log.Println("Error writing data export:", err)
+	}
+}
+
+// writeExportZip writes export as a ZIP archive with one JSON file per section.
+func writeExportZip(w http.ResponseWriter, dir string, export *UserExport) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"posts.json", export.Posts},
+		{"comments.json", export.Comments},
+		{"replies.json", export.Replies},
+		{"votes.json", map[string][]ExportVote{"posts": export.PostVotes, "comments": export.CommentVotes}},
+		{"subforums.json", export.SubForums},
+		{"sessions.json", export.Sessions},
+		{"revisions.json", export.Revisions},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: dir + "/" + file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// DeleteAccountHandler handles POST /settings/delete; wrapped in RequireGuestOrUser.
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := CurrentUser(r)
+	if user.NotRegistered {
+		RenderError(w, "Guests have no account to delete", http.StatusForbidden)
+		return
+	}
+
+	mode := r.FormValue("mode")
+	if mode != DeleteAnonymize && mode != DeleteErase {
+		renderSettings(w, r, http.StatusBadRequest, *user, "delete", "", FieldErrors{"mode": "Choose what happens to your posts and comments"})
+		return
+	}
+	ok, done := checkCurrentPassword(w, r, user, r.FormValue("current_password"))
+	if done {
+		return
+	}
+	if !ok {
+		renderSettings(w, r, http.StatusBadRequest, *user, "delete", "", FieldErrors{"current_password": "Current password is incorrect"})
+		return
+	}
+
+	if err := store.DeleteAccount(user.UUID, mode); err != nil {
+		log.Println("Error deleting account:", err)
+		RenderError(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted account %s (%s)", user.UUID, mode)
+
+	ClearUserCookie(w)
+	InitTemplate(w, r, "templates/notice.html", map[string]interface{}{
+		"Title":    "Account deleted",
+		"Message":  "Your account and personal data have been deleted. Thanks for having been part of the community.",
+		"Link":     "/login",
+		"LinkText": "Back to the start page",
+	})
+}
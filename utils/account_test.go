@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addUser stores a registered user without logging them in.
+func addUser(t *testing.T, s Store, username string) *User {
+	t.Helper()
+	user := &User{UUID: username + "-uuid", Username: username, Email: username + "@example.com", EmailVerified: true, Lastseen: time.Now()}
+	if err := s.CreateUser(*user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestExportIncludesRevisions(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+
+		postID, err := s.CreatePost(alice.UUID, "First title", "first text", []string{"go"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.EditPost(postID, alice.UUID, "Second title", "second text"); err != nil {
+			t.Fatal(err)
+		}
+		commentID, err := s.AddComment(alice.UUID, postID, "a comment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SoftDeleteComment(commentID, alice.UUID); err != nil {
+			t.Fatal(err)
+		}
+		// bob's history isn't alice's to download
+		bobPost, err := s.CreatePost(bob.UUID, "Bob's", "bob's text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.EditPost(bobPost, bob.UUID, "Bob's", "edited"); err != nil {
+			t.Fatal(err)
+		}
+
+		export, err := s.ExportUserData(alice.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ExportRevision{
+			{Kind: RevisionPost, TargetID: postID, Title: "First title", Content: "first text", Reason: RevisionEdit},
+			{Kind: RevisionComment, TargetID: commentID, Content: "a comment", Reason: RevisionDelete},
+		}
+		if len(export.Revisions) != len(want) {
+			t.Fatalf("revisions = %+v, want %d", export.Revisions, len(want))
+		}
+		for i, rev := range export.Revisions {
+			if rev.CreatedAt == "" {
+				t.Errorf("revision %d has no time", i)
+			}
+			rev.CreatedAt = ""
+			if rev != want[i] {
+				t.Errorf("revision %d = %+v, want %+v", i, rev, want[i])
+			}
+		}
+	})
+}
+
+func TestExportZipHasRevisions(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, user := newTestUser(t, h, "alice", "correct horse 1")
+	postID := createPost(t, m, user, "Original", nil)
+	if err := m.EditPost(postID, user.UUID, "Changed", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := alice.get("/settings/export")
+	wantStatus(t, rec, http.StatusOK)
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, "/revisions.json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var revisions []ExportRevision
+		if err := json.Unmarshal(data, &revisions); err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 || revisions[0].Title != "Original" {
+			t.Errorf("revisions.json = %s, want the original post", data)
+		}
+		return
+	}
+	t.Error("export has no revisions.json")
+}
+
+func TestDeleteAccountForgetsThrottle(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		p, _ := testPolicy()
+		p.FreeFailures = 0
+		alice := addUser(t, s, "alice")
+		addUser(t, s, "bob")
+
+		aliceKeys := []string{
+			"login:account:alice",
+			"login:account:alice@example.com",
+			"register:account:alice",
+			"reset:account:alice@example.com",
+			"settings:account:" + alice.UUID,
+		}
+		bobKeys := []string{"login:account:bob", "login:ip:192.0.2.1"}
+		for _, key := range append(aliceKeys, bobKeys...) {
+			if err := s.RecordFailure(p, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := s.DeleteAccount(alice.UUID, DeleteAnonymize); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range aliceKeys {
+			if wait, err := s.ThrottleWait(p, key); err != nil || wait != 0 {
+				t.Errorf("%s after deletion: wait %v, %v; want it forgotten", key, wait, err)
+			}
+		}
+		for _, key := range bobKeys {
+			if wait, err := s.ThrottleWait(p, key); err != nil || wait == 0 {
+				t.Errorf("%s after deleting someone else: wait %v, %v; want it kept", key, wait, err)
+			}
+		}
+
+		if err := s.DeleteAccount(alice.UUID, DeleteAnonymize); err == nil {
+			t.Error("deleting a deleted account succeeded")
+		}
+	})
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, user := newTestUser(t, h, "alice", "correct horse 1")
+	postID := createPost(t, m, user, "Stays", nil)
+
+	form := url.Values{"mode": {DeleteAnonymize}, "current_password": {"wrong"}}
+	wantStatus(t, alice.post("/settings/delete", form), http.StatusBadRequest)
+
+	form.Set("current_password", "correct horse 1")
+	wantStatus(t, alice.post("/settings/delete", form), http.StatusOK)
+	if _, err := m.UserByUUID(user.UUID); err == nil {
+		t.Error("user still exists")
+	}
+	post, err := m.GetPost(postID)
+	if err != nil || post.Author.Username != "deleted" {
+		t.Errorf("post after anonymizing = %+v, %v; want it credited to deleted", post, err)
+	}
+	wantStatus(t, alice.get("/home"), http.StatusSeeOther)
+}
+
+// outline lists a thread as "author: content" lines, replies indented under
+// what they answer.
+func outline(comments []Comment) []string {
+	var lines []string
+	var replies func(rs []Reply, indent string)
+	replies = func(rs []Reply, indent string) {
+		for _, r := range rs {
+			lines = append(lines, indent+r.Author.Username+": "+r.Content)
+			replies(r.Replies, indent+"  ")
+		}
+	}
+	for _, c := range comments {
+		lines = append(lines, c.Author.Username+": "+c.Content)
+		replies(c.Replies, "  ")
+	}
+	return lines
+}
+
+func TestDeleteEraseKeepsOthersReplies(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		postID, err := s.CreatePost(bob.UUID, "Bob's post", "text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread := func() []Comment {
+			t.Helper()
+			comments, _, err := s.CommentsForPost(postID, Page{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return comments
+		}
+		// replyID finds the reply with the given content in the thread.
+		replyID := func(content string) int {
+			t.Helper()
+			var find func(rs []Reply) int
+			find = func(rs []Reply) int {
+				for _, r := range rs {
+					if r.Content == content {
+						return r.ID
+					}
+					if id := find(r.Replies); id != 0 {
+						return id
+					}
+				}
+				return 0
+			}
+			for _, c := range thread() {
+				if id := find(c.Replies); id != 0 {
+					return id
+				}
+			}
+			t.Fatalf("no reply %q", content)
+			return 0
+		}
+		reply := func(user *User, commentID int, parent, content string) {
+			t.Helper()
+			parentID := 0
+			if parent != "" {
+				parentID = replyID(parent)
+			}
+			if err := s.AddReply(user.UUID, commentID, parentID, content); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		answered, err := s.AddComment(alice.UUID, postID, "alice's comment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.EditComment(answered, alice.UUID, "alice's edited comment"); err != nil {
+			t.Fatal(err)
+		}
+		reply(bob, answered, "", "bob answers alice")
+		reply(alice, answered, "bob answers alice", "alice answers bob")
+		reply(alice, answered, "", "alice again")
+		reply(bob, answered, "alice again", "bob again")
+
+		alone, err := s.AddComment(alice.UUID, postID, "nobody answered")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply(alice, alone, "", "talking to herself")
+
+		if err := s.DeleteAccount(alice.UUID, DeleteErase); err != nil {
+			t.Fatal(err)
+		}
+
+		got := strings.Join(outline(thread()), "\n")
+		want := strings.Join([]string{
+			"deleted: [deleted]",
+			"  bob: bob answers alice",
+			"  deleted: [deleted]",
+			"    bob: bob again",
+		}, "\n")
+		if got != want {
+			t.Errorf("thread after erasing alice:\n%s\nwant\n%s", got, want)
+		}
+		revisions, err := s.PostRevisions(postID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 0 {
+			t.Errorf("revisions after erasing alice = %+v, want her old text gone", revisions)
+		}
+	})
+}
+
+func TestDeletedUserMigration(t *testing.T) {
+	d := openTestDB(t)
+	if _, err := d.Rollback(MigrationsDir, schemaVersion(t, d)-11); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Conn.Exec(`INSERT INTO users (uuid, username, email, password, notregistered, lastseen)
+        VALUES ('uuid-1', ' Deleted ', 'del@example.com', '', 0, '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := d.Migrate(MigrationsDir)
+	if err == nil {
+		t.Fatal("migrating with a user called deleted succeeded")
+	}
+	for _, want := range []string{"0012_deleted_user failed", "user  Deleted  (uuid-1) must be renamed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if v := schemaVersion(t, d); v != 11 {
+		t.Errorf("version %d after the refused migration, want 11", v)
+	}
+
+	if _, err := d.Conn.Exec("UPDATE users SET username = 'del' WHERE uuid = 'uuid-1'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Migrate(MigrationsDir); err != nil {
+		t.Fatal(err)
+	}
+	tombstone, err := d.UserByUUID(DeletedUserUUID)
+	if err != nil || tombstone.Username != "deleted" {
+		t.Errorf("tombstone = %+v, %v; want it called deleted", tombstone, err)
+	}
+}
@@ -0,0 +1,392 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// APIPrefix is the mount point of the versioned JSON API.
+const APIPrefix = "/api/v1/"
+
+// APIError is the body of every non-2xx API response.
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// WriteJSON encodes data as the JSON response body.
+func WriteJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println("JSON encode error:", err)
+	}
+}
+
+// RenderJSONError is the API counterpart of RenderError.
+func RenderJSONError(w http.ResponseWriter, message string, statusCode int) {
+	WriteJSON(w, statusCode, map[string]APIError{
+		"error": {Status: statusCode, Message: message},
+	})
+}
+
+// APIHandler routes every request under /api/v1/. Listings are paginated
+// with ?limit= and the opaque ?cursor= values returned in "page" (or
+// "comments_page" for a post's comments):
+//
+//	GET  /api/v1/posts                 list posts (?sort=new|top|controversial|active)
+//	POST /api/v1/posts                 create a post
+//	GET  /api/v1/posts/{id}            a post with a page of its comments
+//	POST /api/v1/posts/{id}/comments   comment on a post
+//	POST /api/v1/posts/{id}/vote       like/dislike a post
+//	POST /api/v1/comments/{id}/vote    like/dislike a comment
+//	GET  /api/v1/categories            list categories
+//	GET  /api/v1/filter                filter posts (?category=, ?filter=myposts|mylikes, ?sort=)
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "posts":
+		switch r.Method {
+		case http.MethodGet:
+			apiListPosts(w, r, PostQuery{})
+		case http.MethodPost:
+			apiCreatePost(w, r)
+		default:
+			RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+
+	case len(parts) == 1 && parts[0] == "categories":
+		if r.Method != http.MethodGet {
+			RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		categories, err := store.ListCategories()
+		if err != nil {
+			RenderJSONError(w, "Failed to load categories", http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"categories": nonNil(categories)})
+		return
+
+	case len(parts) == 1 && parts[0] == "filter":
+		if r.Method != http.MethodGet {
+			RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		apiFilter(w, r)
+		return
+
+	case len(parts) >= 2 && (parts[0] == "posts" || parts[0] == "comments"):
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			RenderJSONError(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		action := strings.Join(parts[2:], "/")
+
+		switch {
+		case parts[0] == "posts" && action == "":
+			if r.Method != http.MethodGet {
+				RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			apiGetPost(w, r, id)
+		case parts[0] == "posts" && action == "comments":
+			if r.Method != http.MethodPost {
+				RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			apiAddComment(w, r, id)
+		case action == "vote":
+			if r.Method != http.MethodPost {
+				RenderJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			apiVote(w, r, parts[0], id)
+		default:
+			RenderJSONError(w, "Not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	RenderJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// apiUser returns the registered user behind the session cookie, writing a
+// JSON error and returning ok=false when there is none.
+func apiUser(w http.ResponseWriter, r *http.Request) (uuid string, ok bool) {
+	user := CurrentUser(r)
+	if user == nil {
+		switch err := sessionError(r); {
+		case errors.Is(err, ErrNoSession):
+			RenderJSONError(w, "Not logged in", http.StatusUnauthorized)
+		case errors.Is(err, ErrSessionReplaced):
+			RenderJSONError(w, "Signed out because your account logged in elsewhere", http.StatusUnauthorized)
+		default:
+			RenderJSONError(w, "Session expired. Please log in again.", http.StatusUnauthorized)
+		}
+		return "", false
+	}
+	if user.NotRegistered {
+		RenderJSONError(w, "Guests cannot do that", http.StatusForbidden)
+		return "", false
+	}
+	if !user.EmailVerified {
+		RenderJSONError(w, "Verify your email address first", http.StatusForbidden)
+		return "", false
+	}
+	return user.UUID, true
+}
+
+// decodeJSON reads the request body into v, rejecting unknown fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		RenderJSONError(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// apiListPosts lists the posts matching q in the order named by ?sort=.
+func apiListPosts(w http.ResponseWriter, r *http.Request, q PostQuery) {
+	var ok bool
+	if q.Sort, ok = ParseSort(r.URL.Query().Get("sort")); !ok {
+		RenderJSONError(w, "Unknown sort; use new, top, controversial or active", http.StatusBadRequest)
+		return
+	}
+
+	posts, page, err := store.ListPosts(q, PageFromRequest(r))
+	if errors.Is(err, ErrInvalidCursor) {
+		RenderJSONError(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		RenderJSONError(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"posts": nonNil(posts), "page": page})
+}
+
+func apiFilter(w http.ResponseWriter, r *http.Request) {
+	var q PostQuery
+	q.Category = r.URL.Query().Get("category")
+
+	switch r.URL.Query().Get("filter") {
+	case "":
+	case "myposts", "mylikes":
+		uuid := currentUUID(r)
+		if uuid == "" {
+			RenderJSONError(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("filter") == "myposts" {
+			q.AuthorUUID = uuid
+		} else {
+			q.LikedBy = uuid
+		}
+	default:
+		RenderJSONError(w, "Unknown filter", http.StatusBadRequest)
+		return
+	}
+
+	if q == (PostQuery{}) {
+		RenderJSONError(w, "Provide a category or filter", http.StatusBadRequest)
+		return
+	}
+	apiListPosts(w, r, q)
+}
+
+func apiGetPost(w http.ResponseWriter, r *http.Request, id int) {
+	post, err := store.GetPost(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			RenderJSONError(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		RenderJSONError(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+	comments, page, err := store.CommentsForPost(id, PageFromRequest(r))
+	if errors.Is(err, ErrInvalidCursor) {
+		RenderJSONError(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		RenderJSONError(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	post.Comments = nonNil(comments)
+	WriteJSON(w, http.StatusOK, struct {
+		*Post
+		CommentsPage PageInfo `json:"comments_page"`
+	}{post, page})
+}
+
+func apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Title      string   `json:"title"`
+		Content    string   `json:"content"`
+		Categories []string `json:"categories"`
+		SubForum   string   `json:"subforum"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	var forum *SubForum
+	if body.SubForum != "" {
+		var err error
+		forum, err = store.SubForumByName(body.SubForum)
+		if err != nil {
+			RenderJSONError(w, "Sub-forum not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := store.CreatePost(uuid, body.Title, body.Content, body.Categories, forum)
+	if errors.Is(err, ErrEmptyPost) {
+		RenderJSONError(w, "Title and content cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Error creating post:", err)
+		RenderJSONError(w, "Failed to create post", http.StatusInternalServerError)
+		return
+	}
+
+	post, err := store.GetPost(id)
+	if err != nil {
+		RenderJSONError(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"posts/"+strconv.Itoa(id))
+	WriteJSON(w, http.StatusCreated, post)
+}
+
+func apiAddComment(w http.ResponseWriter, r *http.Request, postID int) {
+	uuid, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		RenderJSONError(w, "Comment cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	post, err := store.GetPost(postID)
+	if err != nil {
+		RenderJSONError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if post.Deleted {
+		RenderJSONError(w, "This post has been deleted", http.StatusGone)
+		return
+	}
+
+	id, err := store.AddComment(uuid, postID, body.Content)
+	if err != nil {
+		RenderJSONError(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+
+	comment, err := store.GetComment(id)
+	if err != nil {
+		RenderJSONError(w, "Failed to load comment", http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, comment)
+}
+
+func apiVote(w http.ResponseWriter, r *http.Request, target string, id int) {
+	uuid, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Vote string `json:"vote"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	var kind VoteKind
+	switch body.Vote {
+	case "like":
+		kind = VoteLike
+	case "dislike":
+		kind = VoteDislike
+	default:
+		RenderJSONError(w, `vote must be "like" or "dislike"`, http.StatusBadRequest)
+		return
+	}
+
+	// Deleted posts and comments, and comments under deleted posts, take no votes
+	var gone string
+	var err error
+	if target == "comments" {
+		var comment *Comment
+		if comment, err = store.GetComment(id); err != nil {
+			RenderJSONError(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		if gone, err = commentGone(comment); err != nil {
+			RenderJSONError(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var post *Post
+		if post, err = store.GetPost(id); err != nil {
+			RenderJSONError(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		if post.Deleted {
+			gone = "This post has been deleted"
+		}
+	}
+	if gone != "" {
+		RenderJSONError(w, gone, http.StatusGone)
+		return
+	}
+
+	var state VoteKind
+	if target == "comments" {
+		state, err = store.VoteComment(uuid, id, kind)
+	} else {
+		state, err = store.VotePost(uuid, id, kind)
+	}
+	if err != nil {
+		RenderJSONError(w, "Failed to "+kind.Verb(), http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]string{"vote": state.String()})
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
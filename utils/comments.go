@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"database/sql"
+	"time"
+)
+
+// commentKeyset orders a post's comments newest first.
+var commentKeyset = keyset{name: "comments", columns: []string{"comments.created_at"}, id: "comments.id"}
+
+// commentKey returns a comment's key in commentKeyset.
+func commentKey(c Comment) []interface{} {
+	return []interface{}{sessionTime(c.CreatedAt), int64(c.ID)}
+}
+
+// CommentsForPost loads a page of a post's comments, newest first, with their vote counts and reply trees.
+func (db *DataBase) CommentsForPost(postID int, page Page) ([]Comment, PageInfo, error) {
+	cur, err := commentKeyset.decode(page.Cursor)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	where := "comments.post_id = ?"
+	args := []interface{}{postID}
+	if cur != nil {
+		cond, keyArgs := commentKeyset.where(cur)
+		where += " AND " + cond
+		args = append(args, keyArgs...)
+	}
+	size := page.size()
+	args = append(args, size+1)
+
+	rows, err := db.Conn.Query(`
+        SELECT comments.id, comments.content, users.uuid,
+            CASE WHEN comments.deleted_at IS NULL THEN users.username ELSE 'deleted' END,
+            comments.created_at, comments.updated_at, comments.edited_at, comments.deleted_at IS NOT NULL,
+            comments.like_count, comments.dislike_count
+        FROM comments
+        JOIN users ON comments.comment_author_uuid = users.uuid
+        WHERE `+where+`
+        ORDER BY `+commentKeyset.orderBy(cur)+`
+        LIMIT ?
+    `, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		c := Comment{Post: Post{ID: postID}}
+		var createdAt, updatedAt string
+		var editedAt sql.NullString
+		if err := rows.Scan(&c.ID, &c.Content, &c.Author.UUID, &c.Author.Username, &createdAt, &updatedAt, &editedAt, &c.Deleted, &c.LikeCount, &c.DislikeCount); err != nil {
+			return nil, PageInfo{}, err
+		}
+		c.CreatedAt, _ = parseTimestamp(createdAt)
+		c.UpdatedAt, _ = parseTimestamp(updatedAt)
+		c.EditedAt = parseEditedAt(editedAt)
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	comments, info := pageOf(commentKeyset, comments, size, cur, commentKey)
+
+	ids := make([]int, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	replies, err := db.RepliesForComments(postID, ids)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	for i := range comments {
+		comments[i].Replies = replies[comments[i].ID]
+	}
+	return comments, info, nil
+}
+
+// GetComment loads a single comment; only the ID of its post is filled in.
+func (db *DataBase) GetComment(commentID int) (*Comment, error) {
+	var c Comment
+	var createdAt, updatedAt string
+	var editedAt sql.NullString
+	err := db.Conn.QueryRow(`
+        SELECT comments.id, comments.content, comments.post_id, users.uuid,
+            CASE WHEN comments.deleted_at IS NULL THEN users.username ELSE 'deleted' END,
+            comments.created_at, comments.updated_at, comments.edited_at, comments.deleted_at IS NOT NULL
+        FROM comments
+        JOIN users ON comments.comment_author_uuid = users.uuid
+        WHERE comments.id = ?
+    `, commentID).Scan(&c.ID, &c.Content, &c.Post.ID, &c.Author.UUID, &c.Author.Username, &createdAt, &updatedAt, &editedAt, &c.Deleted)
+	if err != nil {
+		return nil, err
+	}
+	c.CreatedAt, _ = parseTimestamp(createdAt)
+	c.UpdatedAt, _ = parseTimestamp(updatedAt)
+	c.EditedAt = parseEditedAt(editedAt)
+	return &c, nil
+}
+
+// AddComment stores a comment on a post.
+func (db *DataBase) AddComment(uuid string, postID int, content string) (int, error) {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	now := sessionTime(time.Now())
+	res, err := db.Conn.Exec("INSERT INTO comments (content, comment_author_uuid, post_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		content, uuid, postID, now, now)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	return int(id), nil
+}
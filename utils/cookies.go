@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"errors"
 	"net/http"
 	"time"
 )
@@ -9,30 +8,28 @@ import (
 // Cookie name we'll use to track the logged-in user
 const SessionCookieName = "user"
 
-// SetUserCookie creates a secure cookie with the user UUID
-func SetUserCookie(w http.ResponseWriter, uuid string) {
+// SetUserCookie stores the opaque session token in the browser
+func SetUserCookie(w http.ResponseWriter, token string, expires time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
-		Value:    uuid,
+		Value:    token,
 		Path:     "/",  // available to all routes
 		HttpOnly: true, // JS can't read it
 		SameSite: http.SameSiteLaxMode,
-		Secure:   false,                         // change to true in production with HTTPS
-		Expires:  time.Now().Add(1 * time.Hour), // cookie valid for 1 hour
+		Secure:   false, // change to true in production with HTTPS
+		Expires:  expires,
 	})
 }
 
-// GetUserFromCookie retrieves the UUID stored in the cookie
-func GetUserFromCookie(r *http.Request) (string, error) {
-    cookie, err := r.Cookie(SessionCookieName)
-    if err != nil {
-        if errors.Is(err, http.ErrNoCookie) {
-            return "", nil // no cookie, but not a crash
-        }
-        return "", err
-    }
-    return cookie.Value, nil
+// GetSessionToken returns the session token from the cookie, or "" if there is none
+func GetSessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
 }
+
 // ClearUserCookie removes the user cookie (for logout)
 func ClearUserCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
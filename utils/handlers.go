@@ -1,12 +1,12 @@
 package utils
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -14,10 +14,14 @@ import (
 
 var tpl *template.Template
 
-// InitTemplate parses and executes a template
-func InitTemplate(w http.ResponseWriter, file string, data interface{}) {
+// InitTemplate parses and executes a template; forms in it can use {{csrfField}}
+// and times can be shown with {{timeAgo .CreatedAt}}
+func InitTemplate(w http.ResponseWriter, r *http.Request, file string, data interface{}) {
 	var err error
-	tpl, err = template.ParseFiles(file)
+	tpl, err = template.New(filepath.Base(file)).Funcs(template.FuncMap{
+		"csrfField": func() template.HTML { return csrfField(r) },
+		"timeAgo":   func(t time.Time) string { return timeAgo(t, time.Now()) },
+	}).ParseFiles(file)
 	if err != nil {
 		http.Error(w, "Template parsing error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -29,6 +33,28 @@ func InitTemplate(w http.ResponseWriter, file string, data interface{}) {
 	}
 }
 
+// timeAgo describes t relative to now, e.g. "3 hours ago". Anything older
+// than a month is shown as a date.
+func timeAgo(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 48*time.Hour:
+		return "yesterday"
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	}
+	return t.Local().Format("Jan 2, 2006")
+}
+
 // DefaultHandler redirects "/" to "/login"
 func DefaultHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -37,19 +63,27 @@ func DefaultHandler(w http.ResponseWriter, r *http.Request) {
 func GuestHandler(w http.ResponseWriter, r *http.Request) {
 	// ✅ If it's a GET request → create a guest session
 	if r.Method == http.MethodGet {
-		user, err := db.Guest()
+		user, err := Guest()
 		if err != nil {
 			RenderError(w, "We couldn’t create a guest session. Please try again.", http.StatusInternalServerError)
 			return
 		}
 
 		// ✅ Check if a user session already exists
-		if cookie, err := r.Cookie("user"); err == nil && cookie.Value != "" {
-			db.DeleteUser(cookie.Value)
+		if token := GetSessionToken(r); token != "" {
+			if old, err := store.SessionByToken(token); old != nil {
+				store.DeleteUser(old.UserUUID)
+			} else if err != nil && !errors.Is(err, ErrNoSession) {
+				log.Println("Error looking up previous session:", err)
+			}
+			store.RevokeSession(token, RevokeLogout)
 		}
 
-		// ✅ Set cookie manually
-		SetUserCookie(w, user.UUID)
+		// ✅ Start the guest's session
+		if err := StartSession(w, r, user.UUID); err != nil {
+			RenderError(w, "We couldn’t create a guest session. Please try again.", http.StatusInternalServerError)
+			return
+		}
 
 		// ✅ Redirect to /home
 		http.Redirect(w, r, "/home", http.StatusSeeOther)
@@ -67,24 +101,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	// Mark user as logged out
-	_, err = db.Conn.Exec("UPDATE users SET loggedin = 0 WHERE uuid = ?", uuid)
-	if err != nil {
-		http.Error(w, "Failed to log out", http.StatusInternalServerError)
-		return
-	}
-
-	// Clear cookie using helper
-	ClearUserCookie(w)
-
-	// Redirect to login page
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	Logout(w, r)
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -93,125 +110,136 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		email := r.FormValue("email")
 		password := r.FormValue("password")
 
+		// Slow down repeated failures from this IP or against this account
+		keys := throttleKeys(r, scopeLogin, username, email)
+		wait, err := store.ThrottleWait(AuthThrottle, keys...)
+		if err != nil {
+			RenderError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			RenderTooManyRequests(w, wait)
+			return
+		}
+
 		// Authenticate
-		user, err := db.Login(w, r, username, email, password)
+		user, err := Login(w, r, username, email, password)
 		if err != nil {
+			if errors.Is(err, ErrInvalidCredentials) {
+				if err := store.RecordFailure(AuthThrottle, keys...); err != nil {
+					log.Println("Error recording failed login:", err)
+				}
+			}
 			RenderError(w, "Invalid username, email, or password", http.StatusBadRequest)
 
 			return
 		}
 
-		// Store cookie
-		SetUserCookie(w, user.UUID)
+		// A successful login clears the account's record, but not the IP's
+		if err := store.ClearFailures(keys[1:]...); err != nil {
+			log.Println("Error clearing failed logins:", err)
+		}
+
+		// Start a new session
+		if err := StartSession(w, r, user.UUID); err != nil {
+			RenderError(w, "Failed to start session", http.StatusInternalServerError)
+			return
+		}
 
 		// Redirect (doesn't show POST response to user)
 		http.Redirect(w, r, "/home", http.StatusSeeOther)
 		return
 	}
 	if r.Method == http.MethodGet {
-		uuid, err := GetUserFromCookie(r)
-		if err == nil && uuid != "" {
+		if CurrentUser(r) != nil {
 			// User already logged in → redirect to home
 			http.Redirect(w, r, "/home", http.StatusSeeOther)
 			return
 		}
 
+		// Explain why a browser that was signed out by a newer login ended up here
+		data := map[string]interface{}{}
+		if errors.Is(sessionError(r), ErrSessionReplaced) {
+			ClearUserCookie(w)
+			data["Notice"] = "You were signed out because your account logged in from another browser."
+		}
+
 		// Otherwise show login form
-		InitTemplate(w, "templates/login.html", nil)
+		InitTemplate(w, r, "templates/login.html", data)
 		return
 	}
 
 	RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// HomeHandler lists a page of posts, ordered by ?sort=; wrapped in RequireGuestOrUser
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	// Get UUID from cookie
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
+	user := CurrentUser(r)
+	order, _ := ParseSort(r.URL.Query().Get("sort"))
 
-	// Check session validity
-	if err := db.CheckSession(w, uuid); err != nil {
-		RenderError(w, "Session expired. Please log in again.", http.StatusUnauthorized)
+	posts, page, err := store.ListPosts(PostQuery{Sort: order}, PageFromRequest(r))
+	if errors.Is(err, ErrInvalidCursor) {
+		RenderError(w, "This page link is not valid", http.StatusBadRequest)
 		return
 	}
-
-	// Refresh session
-	_ = db.RefreshSession(uuid)
-
-	// ✅ Fetch posts from DB
-	rows, err := db.Conn.Query(`
-        SELECT posts.id, posts.title, posts.content, users.username
-        FROM posts
-        JOIN users ON posts.author_uuid = users.uuid
-        ORDER BY posts.id DESC
-    `)
 	if err != nil {
 		RenderError(w, "Failed to load posts", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var posts []map[string]string
-	for rows.Next() {
-		var id int
-		var title, content, author string
-		if err := rows.Scan(&id, &title, &content, &author); err != nil {
-			continue
-		}
-		// Count comments
-		var commentCount int
-		db.Conn.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ?", id).Scan(&commentCount)
-
-		// Count likes
-		var likeCount int
-		db.Conn.QueryRow("SELECT COUNT(*) FROM interactions WHERE post_id = ? AND liked = 1", id).Scan(&likeCount)
-
-		posts = append(posts, map[string]string{
-			"ID":           fmt.Sprint(id),
-			"Title":        title,
-			"Content":      content,
-			"Author":       author,
-			"CommentCount": fmt.Sprint(commentCount),
-			"LikeCount":    fmt.Sprint(likeCount),
-		})
-	}
-	var notRegistered bool
-	err = db.Conn.QueryRow("SELECT notregistered FROM users WHERE uuid = ?", uuid).Scan(&notRegistered)
+	forums, err := store.SubForumNames()
 	if err != nil {
-		RenderError(w, "Failed to check user", http.StatusInternalServerError)
+		RenderError(w, "Failed to load sub-forums", http.StatusInternalServerError)
 		return
 	}
 
-	catRows, err := db.Conn.Query("SELECT name FROM categories ORDER BY name ASC")
+	categories, err := store.ListCategories()
 	if err != nil {
 		RenderError(w, "Failed to load categories", http.StatusInternalServerError)
 		return
 	}
-	defer catRows.Close()
-
-	var categories []string
-
-	for catRows.Next() {
-		var name string
-		if err := catRows.Scan(&name); err == nil {
-			categories = append(categories, name)
-		}
-	}
 
 	// Render template with posts
 	data := map[string]interface{}{
-		"UUID":          uuid,
+		"UUID":          user.UUID,
 		"Posts":         posts,
-		"NotRegistered": notRegistered,
+		"NotRegistered": user.NotRegistered,
+		"Unverified":    !user.NotRegistered && !user.EmailVerified,
+		"Email":         user.Email,
 		"Categories":    categories,
+		"SubForums":     forums,
+		"Sorts":         sortLinks(r, order),
 	}
-	InitTemplate(w, "templates/home.html", data)
+	data["PrevPage"], data["NextPage"] = pageLinks(r, page)
+	InitTemplate(w, r, "templates/home.html", data)
+}
+
+// SortLink is one of the sort orders offered above a post listing.
+type SortLink struct {
+	Label  string
+	URL    string
+	Active bool
 }
 
-func (db *DataBase) Guest() (*User, error) {
+// sortLinks links the first page in each sort order, keeping the other
+// query parameters.
+func sortLinks(r *http.Request, current PostSort) []SortLink {
+	links := make([]SortLink, 0, len(PostSorts))
+	for _, order := range PostSorts {
+		query := r.URL.Query()
+		query.Set("sort", string(order))
+		query.Del("cursor")
+		links = append(links, SortLink{
+			Label:  order.Label(),
+			URL:    r.URL.Path + "?" + query.Encode(),
+			Active: order == current,
+		})
+	}
+	return links
+}
+
+// Guest creates a guest account with a generated username.
+func Guest() (*User, error) {
 	uuid, err := GenerateUserID()
 	if err != nil {
 		return nil, err
@@ -226,7 +254,7 @@ func (db *DataBase) Guest() (*User, error) {
 		Lastseen:      time.Now(),
 	}
 
-	if err := db.SafeWriter("users", user); err != nil {
+	if err := store.CreateUser(user); err != nil {
 		return nil, err
 	}
 
@@ -235,34 +263,82 @@ func (db *DataBase) Guest() (*User, error) {
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		username := r.FormValue("username")
-		email := r.FormValue("email")
+		username := NormalizeUsername(r.FormValue("username"))
+		email := NormalizeEmail(r.FormValue("email"))
 		password := r.FormValue("password")
 		confirmPassword := r.FormValue("confirm_password")
 
-		// Validate form fields
-		if username == "" || email == "" || password == "" || confirmPassword == "" {
-
-			RenderError(w, "All fields are required", http.StatusBadRequest)
+		// Slow down clients probing for taken usernames and emails
+		keys := throttleKeys(r, scopeRegister, username, email)
+		wait, err := store.ThrottleWait(AuthThrottle, keys...)
+		if err != nil {
+			RenderError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			RenderTooManyRequests(w, wait)
 			return
 		}
 
-		if password != confirmPassword {
-
-			RenderError(w, "Passwords do not match", http.StatusBadRequest)
+		// Validate form fields
+		errs := RegistrationValidator.Validate(map[string]string{
+			"username":         username,
+			"email":            email,
+			"password":         password,
+			"confirm_password": confirmPassword,
+		})
+		if errs["confirm_password"] == "" && password != confirmPassword {
+			errs["confirm_password"] = "Passwords do not match"
+		}
+		if len(errs) > 0 {
+			renderRegisterForm(w, r, http.StatusBadRequest, username, email, errs)
 			return
 		}
 
-		// Register user
-		user, err := db.Register(w, username, email, password)
+		// A guest keeps their row, so whatever is tied to their UUID carries over.
+		// If the row vanished meanwhile (e.g. to the janitor) register afresh.
+		var user *User
+		upgraded := false
+		if guest := CurrentUser(r); guest != nil && guest.NotRegistered {
+			user, err = store.UpgradeGuest(guest.UUID, username, email, password)
+			upgraded = !errors.Is(err, ErrNotGuest)
+		}
+		if !upgraded {
+			user, err = Register(username, email, password)
+		}
+		if errors.Is(err, ErrUserExists) {
+			if err := store.RecordFailure(AuthThrottle, keys...); err != nil {
+				log.Println("Error recording failed sign-up:", err)
+			}
+			errs := FieldErrors{"email": "That email is already registered"}
+			if errors.Is(err, ErrUsernameTaken) {
+				errs = FieldErrors{"username": "That username is taken"}
+			}
+			renderRegisterForm(w, r, http.StatusBadRequest, username, email, errs)
+			return
+		}
 		if err != nil {
+			log.Println("Failed to register user:", err)
+			RenderError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
-			RenderError(w, "Username or email already exists", http.StatusBadRequest)
+		// Start a new session; the guest's one is retired so the new
+		// privileges never ride on a token issued before sign-up
+		if upgraded {
+			if err := store.RevokeSession(GetSessionToken(r), RevokeUpgraded); err != nil {
+				log.Println("Error revoking guest session:", err)
+			}
+		}
+		if err := StartSession(w, r, user.UUID); err != nil {
+			RenderError(w, "Failed to start session", http.StatusInternalServerError)
 			return
 		}
 
-		// Store cookie
-		SetUserCookie(w, user.UUID)
+		// The account can post once the email is confirmed; it can be resent from /home
+		if err := SendVerificationEmail(user); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
 
 		// Redirect to home
 		http.Redirect(w, r, "/home", http.StatusSeeOther)
@@ -270,10 +346,24 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Show registration form
-	InitTemplate(w, "templates/register.html", nil)
+	renderRegisterForm(w, r, http.StatusOK, "", "", FieldErrors{})
 }
 
-func (db *DataBase) Register(w http.ResponseWriter, username, email, password string) (*User, error) {
+// renderRegisterForm shows register.html with the submitted values and any per-field errors.
+func renderRegisterForm(w http.ResponseWriter, r *http.Request, status int, username, email string, errs FieldErrors) {
+	w.WriteHeader(status)
+	user := CurrentUser(r)
+	InitTemplate(w, r, "templates/register.html", map[string]interface{}{
+		"Username": username,
+		"Email":    email,
+		"Errors":   errs,
+		"Guest":    user != nil && user.NotRegistered,
+	})
+}
+
+// Register creates a registered account, hashing the password. It returns
+// ErrUsernameTaken or ErrEmailTaken if either is in use.
+func Register(username, email, password string) (*User, error) {
 	uuid, err := GenerateUserID()
 	if err != nil {
 		return nil, err
@@ -281,24 +371,10 @@ func (db *DataBase) Register(w http.ResponseWriter, username, email, password st
 
 	hash, err := HashPassword(password)
 	if err != nil {
-		log.Println("Failed to hash password:", err)
-		RenderError(w, "Internal server error", http.StatusInternalServerError)
-		return nil, err
+		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 	password = hash
 
-	// Check if user already exists
-	var existing User
-	err = db.Conn.QueryRow("SELECT uuid FROM users WHERE username = ? OR email = ?", username, email).Scan(&existing.UUID)
-	if err != sql.ErrNoRows {
-		if err != nil {
-			log.Println("Database error:", err)
-			RenderError(w, "Internal server error", http.StatusInternalServerError)
-			return nil, err
-		}
-		return nil, errors.New("user with this username or email already exists")
-	}
-
 	// Create new user
 	user := User{
 		UUID:          uuid,
@@ -309,40 +385,33 @@ func (db *DataBase) Register(w http.ResponseWriter, username, email, password st
 		Lastseen:      time.Now(),
 	}
 
-	// Insert safely using SafeWriter
-	if err := db.SafeWriter("users", user); err != nil {
-		log.Println("Failed to insert user:", err)
-		RenderError(w, "Internal server error", http.StatusInternalServerError)
-		return nil, err
+	// Insert safely using SafeWriter; the unique indexes catch taken names
+	if err := store.CreateUser(user); err != nil {
+		if errors.Is(err, ErrUserExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to insert user: %w", err)
 	}
 
 	return &user, nil
 }
 
-// CreatePostHandler handles creating new posts
+// CreatePostHandler handles creating new posts; wrapped in RequireRegistered
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
-		// Not logged in → redirect to login
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	// Ensure only registered users can post
-	var notRegistered bool
-	err = db.Conn.QueryRow("SELECT notregistered FROM users WHERE uuid = ?", uuid).Scan(&notRegistered)
-	if err != nil {
-		RenderError(w, "Failed to check user type", http.StatusInternalServerError)
-		return
-	}
-	if notRegistered {
-		RenderError(w, "Guests cannot create posts", http.StatusForbidden)
-		return
-	}
+	uuid := CurrentUser(r).UUID
 
 	if r.Method == http.MethodGet {
+		forums, err := store.SubForumNames()
+		if err != nil {
+			RenderError(w, "Failed to load sub-forums", http.StatusInternalServerError)
+			return
+		}
+
 		// Show form template
-		InitTemplate(w, "templates/create_post.html", nil)
+		InitTemplate(w, r, "templates/create_post.html", map[string]interface{}{
+			"SubForums": forums,
+			"Selected":  r.URL.Query().Get("forum"),
+		})
 		return
 	}
 
@@ -350,40 +419,28 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		title := r.FormValue("title")
 		content := r.FormValue("content")
 		rawCats := r.FormValue("categories") // multiple values
-		if title == "" || content == "" {
+
+		// Optional sub-forum to file the post under
+		var forum *SubForum
+		if name := r.FormValue("subforum"); name != "" {
+			var err error
+			forum, err = store.SubForumByName(name)
+			if err != nil {
+				RenderError(w, "Sub-forum not found", http.StatusBadRequest)
+				return
+			}
+		}
+
+		_, err := store.CreatePost(uuid, title, content, strings.Split(rawCats, ","), forum)
+		if errors.Is(err, ErrEmptyPost) {
 			RenderError(w, "Title and content cannot be empty", http.StatusBadRequest)
 			return
 		}
-
-		res, err := db.Conn.Exec("INSERT INTO posts (title, content, author_uuid) VALUES (?, ?, ?)", title, content, uuid)
 		if err != nil {
-			RenderError(w, "Failed to create post: "+err.Error(), http.StatusInternalServerError)
+			log.Println("Error creating post:", err)
+			RenderError(w, "Failed to create post", http.StatusInternalServerError)
 			return
 		}
-		postID64, _ := res.LastInsertId()
-		postID := int(postID64)
-
-		categories := strings.Split(rawCats, ",")
-
-		for i := range categories {
-			categories[i] = strings.TrimSpace(categories[i])
-		}
-		// Insert categories
-		for _, cat := range categories {
-			// Ensure category exists
-			var catID int
-			err := db.Conn.QueryRow("SELECT id FROM categories WHERE name = ?", cat).Scan(&catID)
-			if err == sql.ErrNoRows {
-				res, err := db.Conn.Exec("INSERT INTO categories (name) VALUES (?)", cat)
-				if err == nil {
-					catID64, _ := res.LastInsertId()
-					catID = int(catID64)
-				}
-			}
-			if catID > 0 {
-				db.Conn.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)", postID, catID)
-			}
-		}
 
 		// Redirect back to home after success
 		http.Redirect(w, r, "/home", http.StatusSeeOther)
@@ -395,258 +452,207 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 
 // PostHandler handles viewing a single post and adding comments
 func PostHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract post ID from URL path
-	postIDStr := strings.TrimPrefix(r.URL.Path, "/post/")
+	// Extract post ID (and optional sub-route) from URL path
+	postIDStr, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/post/"), "/")
 	postID, err := strconv.Atoi(postIDStr)
 	if err != nil {
 		RenderError(w, "Invalid post ID", http.StatusBadRequest)
 		return
 	}
 
-	// Fetch post from DB
-	var title, content, author string
-	err = db.Conn.QueryRow(`
-        SELECT posts.title, posts.content, users.username
-        FROM posts
-        JOIN users ON posts.author_uuid = users.uuid
-        WHERE posts.id = ?
-    `, postID).Scan(&title, &content, &author)
+	post, err := store.GetPost(postID)
 	if err != nil {
 		RenderError(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
+	switch action {
+	case "":
+	case "reply":
+		RequireRegistered(func(w http.ResponseWriter, r *http.Request) {
+			handleReply(w, r, postID)
+		})(w, r)
+		return
+	case "edit", "delete", "history":
+		RequireRegistered(func(w http.ResponseWriter, r *http.Request) {
+			switch action {
+			case "edit":
+				handleEditPost(w, r, post)
+			case "delete":
+				handleDeletePost(w, r, post)
+			default:
+				handleHistory(w, r, post)
+			}
+		})(w, r)
+		return
+	case "comment-edit", "comment-delete":
+		RequireRegistered(func(w http.ResponseWriter, r *http.Request) {
+			if action == "comment-edit" {
+				handleEditComment(w, r, postID)
+			} else {
+				handleDeleteComment(w, r, postID)
+			}
+		})(w, r)
+		return
+	default:
+		RenderError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
 	// If POST → add comment
 	if r.Method == http.MethodPost {
-		uuid, err := GetUserFromCookie(r)
-		if err != nil || uuid == "" {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
-
-		var notRegistered bool
-		err = db.Conn.QueryRow("SELECT notregistered FROM users WHERE uuid = ?", uuid).Scan(&notRegistered)
-		if err != nil {
-			RenderError(w, "Failed to check user type", http.StatusInternalServerError)
-			return
-		}
-		if notRegistered {
-			RenderError(w, "Guests cannot comment", http.StatusForbidden)
-			return
-		}
-
-		content := r.FormValue("comment")
-		if content == "" {
-			RenderError(w, "Comment cannot be empty", http.StatusBadRequest)
-			return
-		}
+		RequireRegistered(func(w http.ResponseWriter, r *http.Request) {
+			handleComment(w, r, postID)
+		})(w, r)
+		return
+	}
 
-		_, err = db.Conn.Exec("INSERT INTO comments (content, comment_author_uuid, post_id) VALUES (?, ?, ?)", content, uuid, postID)
-		if err != nil {
-			RenderError(w, "Failed to add comment", http.StatusInternalServerError)
-			return
-		}
+	// The viewer's own votes are highlighted; anonymous viewers have none
+	viewer := currentUUID(r)
 
-		// Redirect to same post page
-		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+	postComments, page, err := store.CommentsForPost(postID, PageFromRequest(r))
+	if errors.Is(err, ErrInvalidCursor) {
+		RenderError(w, "This page link is not valid", http.StatusBadRequest)
 		return
 	}
-
-	// Fetch comments for this post
-	rows, err := db.Conn.Query(`
-        SELECT comments.content, users.username
-        FROM comments
-        JOIN users ON comments.comment_author_uuid = users.uuid
-        WHERE comments.post_id = ?
-        ORDER BY comments.id DESC
-    `, postID)
 	if err != nil {
 		RenderError(w, "Failed to load comments", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	commentVotes, err := store.CommentVotes(viewer, postID)
+	if err != nil {
+		RenderError(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
 
-	var comments []map[string]string
-	for rows.Next() {
-		var cContent, cAuthor string
-		if err := rows.Scan(&cContent, &cAuthor); err == nil {
-			comments = append(comments, map[string]string{
-				"Author":  cAuthor,
-				"Content": cContent,
-			})
+	var comments []map[string]interface{}
+	for _, c := range postComments {
+		if c.Deleted || post.Deleted {
+			closeReplies(c.Replies)
 		}
+		comments = append(comments, map[string]interface{}{
+			"ID":        c.ID,
+			"Author":    c.Author.Username,
+			"Content":   c.Content,
+			"Likes":     c.LikeCount,
+			"Dislikes":  c.DislikeCount,
+			"Liked":     commentVotes[c.ID] == VoteLike,
+			"Disliked":  commentVotes[c.ID] == VoteDislike,
+			"Replies":   c.Replies,
+			"CreatedAt": c.CreatedAt,
+			"EditedAt":  c.EditedAt,
+			"Deleted":   c.Deleted,
+			"IsAuthor":  viewer != "" && c.Author.UUID == viewer,
+		})
 	}
-	// Count likes & dislikes
-	var likeCount, dislikeCount int
-	db.Conn.QueryRow("SELECT COUNT(*) FROM interactions WHERE post_id = ? AND liked = 1", postID).Scan(&likeCount)
-	db.Conn.QueryRow("SELECT COUNT(*) FROM interactions WHERE post_id = ? AND disliked = 1", postID).Scan(&dislikeCount)
+	userVote, _ := store.PostVote(viewer, postID)
+	moderator, _ := store.ModeratesPost(viewer, postID)
 
 	// Render template
 	data := map[string]interface{}{
-		"Title":    title,
-		"Content":  content,
-		"Author":   author,
-		"Comments": comments,
-		"PostID":   postID,
-		"Likes":    likeCount,
-		"Dislikes": dislikeCount,
-	}
-	InitTemplate(w, "templates/post.html", data)
+		"Title":     post.Title,
+		"Content":   post.Content,
+		"Author":    post.Author.Username,
+		"Comments":  comments,
+		"PostID":    postID,
+		"Likes":     post.LikeCount,
+		"Dislikes":  post.DislikeCount,
+		"Liked":     userVote == VoteLike,
+		"Disliked":  userVote == VoteDislike,
+		"CreatedAt": post.CreatedAt,
+		"EditedAt":  post.EditedAt,
+		"Deleted":   post.Deleted,
+		"IsAuthor":  viewer != "" && post.Author.UUID == viewer,
+		"Moderator": moderator,
+	}
+	data["PrevPage"], data["NextPage"] = pageLinks(r, page)
+	InitTemplate(w, r, "templates/post.html", data)
 }
 
-// LikeHandler handles liking a post
-func LikeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+// handleComment handles POST /post/{id}
+func handleComment(w http.ResponseWriter, r *http.Request, postID int) {
+	content := r.FormValue("comment")
+	if content == "" {
+		RenderError(w, "Comment cannot be empty", http.StatusBadRequest)
 		return
 	}
 
-	postID := r.FormValue("post_id")
-	if postID == "" {
-		RenderError(w, "Missing post ID", http.StatusBadRequest)
+	if post, err := store.GetPost(postID); err == nil && post.Deleted {
+		RenderError(w, "This post has been deleted", http.StatusGone)
 		return
 	}
 
-	// Ensure user is registered
-	var notRegistered bool
-	err = db.Conn.QueryRow("SELECT notregistered FROM users WHERE uuid = ?", uuid).Scan(&notRegistered)
-	if err != nil || notRegistered {
-		RenderError(w, "Guests cannot like posts", http.StatusForbidden)
+	if _, err := store.AddComment(CurrentUser(r).UUID, postID, content); err != nil {
+		RenderError(w, "Failed to add comment", http.StatusInternalServerError)
 		return
 	}
 
-	// Remove any existing interaction by this user on this post
-	_, _ = db.Conn.Exec("DELETE FROM interactions WHERE user_uuid = ? AND post_id = ?", uuid, postID)
-
-	// Insert like
-	_, err = db.Conn.Exec("INSERT INTO interactions (user_uuid, post_id, liked, disliked) VALUES (?, ?, 1, 0)", uuid, postID)
-	if err != nil {
-		RenderError(w, "Failed to like post", http.StatusInternalServerError)
-		return
-	}
+	// Redirect to same post page
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
 
-	http.Redirect(w, r, "/post/"+postID, http.StatusSeeOther)
+// LikeHandler handles liking a post; liking an already liked post removes the like
+func LikeHandler(w http.ResponseWriter, r *http.Request) {
+	handlePostVote(w, r, VoteLike)
 }
 
-// DislikeHandler handles disliking a post
+// DislikeHandler handles disliking a post; disliking an already disliked post removes the dislike
 func DislikeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	postID := r.FormValue("post_id")
-	if postID == "" {
-		RenderError(w, "Missing post ID", http.StatusBadRequest)
-		return
-	}
-
-	// Ensure user is registered
-	var notRegistered bool
-	err = db.Conn.QueryRow("SELECT notregistered FROM users WHERE uuid = ?", uuid).Scan(&notRegistered)
-	if err != nil || notRegistered {
-		RenderError(w, "Guests cannot dislike posts", http.StatusForbidden)
-		return
-	}
-
-	// Remove any existing interaction by this user on this post
-	_, _ = db.Conn.Exec("DELETE FROM interactions WHERE user_uuid = ? AND post_id = ?", uuid, postID)
+	handlePostVote(w, r, VoteDislike)
+}
 
-	// Insert dislike
-	_, err = db.Conn.Exec("INSERT INTO interactions (user_uuid, post_id, liked, disliked) VALUES (?, ?, 0, 1)", uuid, postID)
-	if err != nil {
-		RenderError(w, "Failed to dislike post", http.StatusInternalServerError)
-		return
-	}
+// CommentLikeHandler handles liking a comment
+func CommentLikeHandler(w http.ResponseWriter, r *http.Request) {
+	handleCommentVote(w, r, VoteLike)
+}
 
-	http.Redirect(w, r, "/post/"+postID, http.StatusSeeOther)
+// CommentDislikeHandler handles disliking a comment
+func CommentDislikeHandler(w http.ResponseWriter, r *http.Request) {
+	handleCommentVote(w, r, VoteDislike)
 }
 
 func FilterHandler(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
-	if category == "" {
-		http.Redirect(w, r, "/home", http.StatusSeeOther)
-		return
-	}
 	filterType := r.URL.Query().Get("filter")
-	uuid, _ := GetUserFromCookie(r)
+	uuid := currentUUID(r)
 
-	var rows *sql.Rows
-	var err error
+	var q PostQuery
 	var label string
+	q.Sort, _ = ParseSort(r.URL.Query().Get("sort"))
 
 	switch {
 	case category != "":
 		label = "Category: " + category
-		rows, err = db.Conn.Query(`
-            SELECT posts.id, posts.title, posts.content, users.username
-            FROM posts
-            JOIN users ON posts.author_uuid = users.uuid
-            JOIN post_categories ON posts.id = post_categories.post_id
-            JOIN categories ON post_categories.category_id = categories.id
-            WHERE categories.name = ?
-            ORDER BY posts.id DESC
-        `, category)
+		q.Category = category
 
 	case filterType == "myposts" && uuid != "":
 		label = "My Posts"
-		rows, err = db.Conn.Query(`
-            SELECT posts.id, posts.title, posts.content, users.username
-            FROM posts
-            JOIN users ON posts.author_uuid = users.uuid
-            WHERE users.uuid = ?
-            ORDER BY posts.id DESC
-        `, uuid)
+		q.AuthorUUID = uuid
 
 	case filterType == "mylikes" && uuid != "":
 		label = "My Liked Posts"
-		rows, err = db.Conn.Query(`
-            SELECT posts.id, posts.title, posts.content, users.username
-            FROM posts
-            JOIN users ON posts.author_uuid = users.uuid
-            JOIN interactions ON posts.id = interactions.post_id
-            WHERE interactions.user_uuid = ? AND interactions.liked = 1
-            ORDER BY posts.id DESC
-        `, uuid)
+		q.LikedBy = uuid
 
 	default:
 		http.Redirect(w, r, "/home", http.StatusSeeOther)
 		return
 	}
+
+	posts, page, err := store.ListPosts(q, PageFromRequest(r))
+	if errors.Is(err, ErrInvalidCursor) {
+		RenderError(w, "This page link is not valid", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		RenderError(w, "Failed to filter posts", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var posts []map[string]string
-	for rows.Next() {
-		var id int
-		var title, content, author string
-		rows.Scan(&id, &title, &content, &author)
-		posts = append(posts, map[string]string{
-			"ID":      fmt.Sprint(id),
-			"Title":   title,
-			"Content": content,
-			"Author":  author,
-		})
-	}
 
 	data := map[string]interface{}{
 		"FilterLabel": label,
 		"Posts":       posts,
+		"Sorts":       sortLinks(r, q.Sort),
 	}
-	InitTemplate(w, "templates/filter.html", data)
+	data["PrevPage"], data["NextPage"] = pageLinks(r, page)
+	InitTemplate(w, r, "templates/filter.html", data)
 }
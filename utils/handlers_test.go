@@ -0,0 +1,513 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newMemoryServer serves the handlers from a fresh MemoryStore. The SQLite
+// database is never opened, so any handler still reaching for it panics.
+func newMemoryServer(t *testing.T) (http.Handler, *MemoryStore) {
+	t.Helper()
+	if db != nil {
+		t.Fatal("handler tests expect no SQLite database")
+	}
+	m := NewMemoryStore()
+	useStore(t, m)
+	return newTestServer(), m
+}
+
+// createPost adds a post as the user behind c and returns its ID.
+func createPost(t *testing.T, m *MemoryStore, author *User, title string, forum *SubForum) int {
+	t.Helper()
+	id, err := m.CreatePost(author.UUID, title, title+" content", []string{"go"}, forum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestHomeAndPostPages(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	postID := createPost(t, m, aliceUser, "Hello forum", nil)
+
+	anon := &testClient{t: t, handler: h}
+	rec := anon.get("/home")
+	wantStatus(t, rec, http.StatusSeeOther)
+	if loc := rec.Header().Get("Location"); loc != "/login" {
+		t.Errorf("anonymous /home redirects to %q, want /login", loc)
+	}
+
+	rec = alice.get("/home")
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "Hello forum")
+
+	rec = anon.get(fmt.Sprintf("/post/%d", postID))
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "Hello forum", "alice")
+
+	wantStatus(t, anon.get("/post/999"), http.StatusNotFound)
+}
+
+func TestCommentAndReply(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	bob, _ := newTestUser(t, h, "bob", "correct horse 2")
+	postID := createPost(t, m, aliceUser, "Hello forum", nil)
+	path := fmt.Sprintf("/post/%d", postID)
+
+	wantStatus(t, bob.post(path, url.Values{"comment": {"First!"}}), http.StatusSeeOther)
+	comments, _, err := m.CommentsForPost(postID, Page{})
+	if err != nil || len(comments) != 1 {
+		t.Fatalf("comments = %v, %v; want one", comments, err)
+	}
+
+	reply := url.Values{"comment_id": {fmt.Sprint(comments[0].ID)}, "reply": {"Welcome, bob"}}
+	wantStatus(t, alice.post(path+"/reply", reply), http.StatusSeeOther)
+
+	rec := bob.get(path)
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "First!", "Welcome, bob")
+
+	// Forms without the session's CSRF token are refused
+	forged := url.Values{"comment": {"forged"}, CSRFFieldName: {"nope"}}
+	wantStatus(t, bob.post(path, forged), http.StatusForbidden)
+}
+
+// brokenStore is a MemoryStore whose writes fail like a broken database.
+type brokenStore struct{ *MemoryStore }
+
+var errBroken = errors.New("disk I/O error")
+
+func (brokenStore) AddReply(string, int, int, string) error { return errBroken }
+
+func (brokenStore) CreatePost(string, string, string, []string, *SubForum) (int, error) {
+	return 0, errBroken
+}
+
+func (brokenStore) CreateSubForum(string, string) (*SubForum, error) { return nil, errBroken }
+
+func (brokenStore) AddSubForumAdmin(int, string) error { return errBroken }
+
+// wantHidden fails the test if the page shows errBroken to the browser.
+func wantHidden(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if strings.Contains(rec.Body.String(), errBroken.Error()) {
+		t.Errorf("page shows the internal error: %s", bodySummary(rec.Body.String()))
+	}
+}
+
+func TestReplyErrors(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	postID := createPost(t, m, aliceUser, "Threads", nil)
+	path := fmt.Sprintf("/post/%d", postID)
+	first, err := m.AddComment(aliceUser.UUID, postID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.AddComment(aliceUser.UUID, postID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := MaxReplyDepth
+	MaxReplyDepth = 2
+	t.Cleanup(func() { MaxReplyDepth = old })
+
+	reply := func(commentID int, parentID string) url.Values {
+		return url.Values{"comment_id": {fmt.Sprint(commentID)}, "parent_id": {parentID}, "reply": {"hi"}}
+	}
+	// deepest returns the ID of the last reply in the first comment's thread.
+	deepest := func() int {
+		t.Helper()
+		comments, _, err := m.CommentsForPost(postID, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range comments {
+			if c.ID != first || len(c.Replies) == 0 {
+				continue
+			}
+			r := c.Replies[0]
+			for len(r.Replies) > 0 {
+				r = r.Replies[0]
+			}
+			return r.ID
+		}
+		t.Fatalf("comments = %+v, want a reply under the first", comments)
+		return 0
+	}
+	wantStatus(t, alice.post(path+"/reply", reply(first, "")), http.StatusSeeOther)
+	replyID := deepest()
+	wantStatus(t, alice.post(path+"/reply", reply(first, fmt.Sprint(replyID))), http.StatusSeeOther)
+
+	rec := alice.post(path+"/reply", reply(first, fmt.Sprint(deepest())))
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "Replies cannot be nested more than 2 levels deep")
+
+	rec = alice.post(path+"/reply", reply(second, fmt.Sprint(replyID)))
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "The reply you answered is not part of this comment")
+
+	wantStatus(t, alice.post(path+"/reply", reply(first, "99999")), http.StatusBadRequest)
+
+	useStore(t, brokenStore{m})
+	rec = alice.post(path+"/reply", reply(first, ""))
+	wantStatus(t, rec, http.StatusInternalServerError)
+	wantHidden(t, rec)
+}
+
+func TestCreatePostErrors(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, _ := newTestUser(t, h, "alice", "correct horse 1")
+
+	form := url.Values{"title": {"  "}, "content": {"text"}, "categories": {"go"}}
+	rec := alice.post("/create-post", form)
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "Title and content cannot be empty")
+	rec = alice.postJSON(APIPrefix+"posts", `{"title":"Title","content":" \n "}`)
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "Title and content cannot be empty")
+
+	form.Set("title", "Title")
+	useStore(t, brokenStore{m})
+	rec = alice.post("/create-post", form)
+	wantStatus(t, rec, http.StatusInternalServerError)
+	wantHidden(t, rec)
+	rec = alice.postJSON(APIPrefix+"posts", `{"title":"Title","content":"text"}`)
+	wantStatus(t, rec, http.StatusInternalServerError)
+	wantHidden(t, rec)
+}
+
+func TestDeletePostAsksFirst(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	bob, _ := newTestUser(t, h, "bob", "correct horse 2")
+	postID := createPost(t, m, aliceUser, "Short-lived", nil)
+	path := fmt.Sprintf("/post/%d", postID)
+
+	rec := alice.get(path + "/delete")
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "Delete this post?", `action="`+path+`/delete"`)
+
+	wantStatus(t, bob.get(path+"/delete"), http.StatusForbidden)
+	wantStatus(t, bob.post(path+"/delete", nil), http.StatusForbidden)
+
+	wantStatus(t, alice.post(path+"/delete", nil), http.StatusSeeOther)
+	post, err := m.GetPost(postID)
+	if err != nil || !post.Deleted {
+		t.Fatalf("post after delete = %+v, %v; want it soft-deleted", post, err)
+	}
+
+	wantStatus(t, alice.get(path+"/delete"), http.StatusGone)
+	wantStatus(t, bob.post(path, url.Values{"comment": {"too late"}}), http.StatusGone)
+	wantStatus(t, bob.postJSON(fmt.Sprintf("%sposts/%d/vote", APIPrefix, postID), `{"vote":"like"}`), http.StatusGone)
+}
+
+func TestSubForumAdmin(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	bob, bobUser := newTestUser(t, h, "bob", "correct horse 2")
+
+	rec := alice.post("/create-forum", url.Values{"name": {" Gophers "}})
+	wantStatus(t, rec, http.StatusSeeOther)
+	if loc := rec.Header().Get("Location"); loc != "/f/gophers" {
+		t.Fatalf("new sub-forum redirects to %q, want /f/gophers", loc)
+	}
+	wantStatus(t, bob.post("/create-forum", url.Values{"name": {"gophers"}}), http.StatusBadRequest)
+	wantStatus(t, bob.post("/create-forum", url.Values{"name": {"no spaces"}}), http.StatusBadRequest)
+
+	forum, err := m.SubForumByName("gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	older := createPost(t, m, bobUser, "Older", forum)
+	newer := createPost(t, m, bobUser, "Newer", forum)
+	createPost(t, m, aliceUser, "Elsewhere", nil)
+
+	rec = bob.get("/create-post")
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "gophers")
+
+	// Only admins may pin
+	pin := url.Values{"post_id": {fmt.Sprint(older)}}
+	wantStatus(t, bob.post("/f/gophers/pin", pin), http.StatusForbidden)
+	wantStatus(t, alice.post("/f/gophers/pin", pin), http.StatusSeeOther)
+
+	rec = bob.get("/f/gophers")
+	wantStatus(t, rec, http.StatusOK)
+	body := rec.Body.String()
+	if strings.Contains(body, "Elsewhere") {
+		t.Error("sub-forum lists a post filed elsewhere")
+	}
+	if o, n := strings.Index(body, "Older"), strings.Index(body, "Newer"); o < 0 || n < 0 || o > n {
+		t.Errorf("pinned post should come first: Older at %d, Newer at %d", o, n)
+	}
+
+	// Admins see the history of posts in their sub-forum, others don't
+	history := fmt.Sprintf("/post/%d/history", newer)
+	carol, _ := newTestUser(t, h, "carol", "correct horse 3")
+	wantStatus(t, carol.get(history), http.StatusForbidden)
+	wantStatus(t, alice.get(history), http.StatusOK)
+
+	// Removing a post soft-deletes it on the admin's behalf
+	wantStatus(t, alice.post("/f/gophers/remove", url.Values{"post_id": {fmt.Sprint(newer)}}), http.StatusSeeOther)
+	rec = alice.get(history)
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "alice")
+	wantStatus(t, alice.post("/f/gophers/remove", url.Values{"post_id": {fmt.Sprint(newer)}}), http.StatusGone)
+
+	wantStatus(t, alice.post("/f/gophers/admins", url.Values{"username": {"carol"}}), http.StatusSeeOther)
+	wantStatus(t, carol.get(history), http.StatusOK)
+}
+
+func TestEditAndHistory(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		h := newTestServer()
+		alice, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+		bob, bobUser := newTestUser(t, h, "bob", "correct horse 2")
+		carol, _ := newTestUser(t, h, "carol", "correct horse 3")
+
+		// Outside any sub-forum, so nobody moderates it
+		postID, err := s.CreatePost(aliceUser.UUID, "Original title", "original text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commentID, err := s.AddComment(bobUser.UUID, postID, "bob's first words")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/post/%d", postID)
+		editComment := fmt.Sprintf("%s/comment-edit?comment_id=%d", path, commentID)
+
+		wantStatus(t, bob.get(path+"/edit"), http.StatusForbidden)
+		wantStatus(t, bob.post(path+"/edit", url.Values{"title": {"Bob's"}, "content": {"takeover"}}), http.StatusForbidden)
+		rec := alice.get(path + "/edit")
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "Original title", "original text")
+		rec = alice.post(path+"/edit", url.Values{"title": {"New title"}, "content": {" "}})
+		wantStatus(t, rec, http.StatusBadRequest)
+		wantBody(t, rec, "Title and content cannot be empty")
+		wantStatus(t, alice.post(path+"/edit", url.Values{"title": {"New title"}, "content": {"new text"}}), http.StatusSeeOther)
+
+		wantStatus(t, alice.get(editComment), http.StatusForbidden)
+		wantStatus(t, alice.post(editComment, url.Values{"content": {"alice's words"}}), http.StatusForbidden)
+		rec = bob.get(editComment)
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "bob&#39;s first words")
+		wantStatus(t, bob.post(editComment, url.Values{"content": {""}}), http.StatusBadRequest)
+		wantStatus(t, bob.post(editComment, url.Values{"content": {"bob's second words"}}), http.StatusSeeOther)
+
+		rec = alice.get(path)
+		wantBody(t, rec, "New title", "new text", "bob&#39;s second words", path+"/history")
+
+		// Authors see the earlier versions of what they wrote, and only those
+		rec = alice.get(path + "/history")
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "Original title", "original text")
+		if strings.Contains(rec.Body.String(), "first words") {
+			t.Error("post author sees the commenter's earlier version")
+		}
+		rec = bob.get(path + "/history")
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "bob&#39;s first words")
+		if strings.Contains(rec.Body.String(), "original text") {
+			t.Error("commenter sees the post author's earlier version")
+		}
+		rec = carol.get(path + "/history")
+		wantStatus(t, rec, http.StatusForbidden)
+		wantBody(t, rec, "Only moderators and authors can see the edit history")
+	})
+}
+
+func TestAPIVoteToggles(t *testing.T) {
+	h, m := newMemoryServer(t)
+	_, aliceUser := newTestUser(t, h, "alice", "correct horse 1")
+	bob, _ := newTestUser(t, h, "bob", "correct horse 2")
+	postID := createPost(t, m, aliceUser, "Vote on me", nil)
+	target := fmt.Sprintf("%sposts/%d/vote", APIPrefix, postID)
+
+	tests := []struct {
+		vote  string
+		want  int
+		likes int
+	}{
+		{"like", http.StatusOK, 1},
+		{"like", http.StatusOK, 0},
+		{"dislike", http.StatusOK, 0},
+		{"meh", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		wantStatus(t, bob.postJSON(target, `{"vote":"`+tt.vote+`"}`), tt.want)
+		post, err := m.GetPost(postID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.LikeCount != tt.likes {
+			t.Errorf("after %q: likes = %d, want %d", tt.vote, post.LikeCount, tt.likes)
+		}
+	}
+
+	anon := &testClient{t: t, handler: h}
+	wantStatus(t, anon.postJSON(target, `{"vote":"like"}`), http.StatusUnauthorized)
+}
+
+func TestLoginReplacesOlderSession(t *testing.T) {
+	h, _ := newMemoryServer(t)
+	first, _ := newTestUser(t, h, "alice", "correct horse 1")
+	wantStatus(t, first.get("/home"), http.StatusOK)
+
+	second := &testClient{t: t, handler: h}
+	second.login("alice", "correct horse 1")
+	wantStatus(t, second.get("/home"), http.StatusOK)
+
+	rec := first.get("/home")
+	wantStatus(t, rec, http.StatusSeeOther)
+	if loc := rec.Header().Get("Location"); loc != "/login" {
+		t.Errorf("replaced session redirects to %q, want /login", loc)
+	}
+
+	wantStatus(t, second.post("/logout", nil), http.StatusSeeOther)
+	wantStatus(t, second.get("/home"), http.StatusSeeOther)
+}
+
+func TestRegisterAndVerify(t *testing.T) {
+	h, m := newMemoryServer(t)
+	c := &testClient{t: t, handler: h}
+	form := url.Values{
+		"username":         {"dora"},
+		"email":            {"dora@example.com"},
+		"password":         {"a long passphrase 7"},
+		"confirm_password": {"a long passphrase 7"},
+	}
+	wantStatus(t, c.post("/register", form), http.StatusSeeOther)
+
+	user, err := m.FindUser("dora", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerified {
+		t.Fatal("new account is verified before the link is used")
+	}
+	wantStatus(t, c.post("/create-post", url.Values{"title": {"t"}, "content": {"c"}}), http.StatusForbidden)
+
+	mail := lastMail(t, "dora@example.com")
+	i := strings.Index(mail.Body, "/verify?token=")
+	if i < 0 {
+		t.Fatalf("verification mail has no link:\n%s", mail.Body)
+	}
+	link := strings.Fields(mail.Body[i:])[0]
+	wantStatus(t, c.get(link), http.StatusOK)
+	wantStatus(t, c.get(link[:len(link)-2]), http.StatusBadRequest)
+
+	wantStatus(t, c.post("/create-post", url.Values{"title": {"t"}, "content": {"c"}}), http.StatusSeeOther)
+}
+
+func TestUpgradeGuest(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		h := newTestServer()
+		addUser(t, s, "alice")
+		guest := &testClient{t: t, handler: h}
+		wantStatus(t, guest.get("/guest"), http.StatusSeeOther)
+		guestToken := guest.token
+		session, err := s.SessionByToken(guestToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		guestUUID := session.UserUUID
+		postID, err := s.CreatePost(guestUUID, "Written as a guest", "text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{
+			"username":         {"Alice"},
+			"email":            {"dora@example.com"},
+			"password":         {"a long passphrase 7"},
+			"confirm_password": {"a long passphrase 7"},
+		}
+		rec := guest.post("/register", form)
+		wantStatus(t, rec, http.StatusBadRequest)
+		wantBody(t, rec, "That username is taken")
+		form.Set("username", "dora")
+		form.Set("email", "ALICE@example.com")
+		rec = guest.post("/register", form)
+		wantStatus(t, rec, http.StatusBadRequest)
+		wantBody(t, rec, "That email is already registered")
+
+		user, err := s.UserByUUID(guestUUID)
+		if err != nil || !user.NotRegistered || user.Email != "" {
+			t.Fatalf("guest after refused sign-ups = %+v, %v; want them untouched", user, err)
+		}
+		if guest.token != guestToken {
+			t.Fatal("a refused sign-up replaced the guest's session")
+		}
+		wantStatus(t, guest.get("/home"), http.StatusOK)
+
+		form.Set("email", "dora@example.com")
+		wantStatus(t, guest.post("/register", form), http.StatusSeeOther)
+
+		user, err = s.FindUser("dora", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UUID != guestUUID || user.NotRegistered || user.EmailVerified {
+			t.Errorf("upgraded user = %+v, want the guest's UUID, registered and unverified", user)
+		}
+		post, err := s.GetPost(postID)
+		if err != nil || post.Author.Username != "dora" {
+			t.Errorf("guest's post after sign-up = %+v, %v; want it credited to dora", post, err)
+		}
+
+		if _, err := s.SessionByToken(guestToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("guest session after sign-up: %v, want it revoked", err)
+		}
+		if guest.token == guestToken {
+			t.Fatal("no new session after sign-up")
+		}
+		session, err = s.SessionByToken(guest.token)
+		if err != nil || session.UserUUID != guestUUID {
+			t.Errorf("new session = %+v, %v; want one for the upgraded user", session, err)
+		}
+		wantStatus(t, guest.get("/home"), http.StatusOK)
+
+		if mail := lastMail(t, "dora@example.com"); !strings.Contains(mail.Body, "/verify?token=") {
+			t.Errorf("verification mail has no link:\n%s", mail.Body)
+		}
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	h, m := newMemoryServer(t)
+	old, user := newTestUser(t, h, "alice", "correct horse 1")
+
+	anon := &testClient{t: t, handler: h}
+	wantStatus(t, anon.post("/forgot-password", url.Values{"email": {user.Email}}), http.StatusOK)
+
+	mail := lastMail(t, user.Email)
+	i := strings.Index(mail.Body, "/reset-password?token=")
+	if i < 0 {
+		t.Fatalf("reset mail has no link:\n%s", mail.Body)
+	}
+	link := strings.Fields(mail.Body[i:])[0]
+	wantStatus(t, anon.get(link), http.StatusOK)
+
+	token := strings.TrimPrefix(link, "/reset-password?token=")
+	token, _ = url.QueryUnescape(token)
+	form := url.Values{"token": {token}, "password": {"battery staple 2"}, "confirm_password": {"battery staple 2"}}
+	wantStatus(t, anon.post("/reset-password", form), http.StatusOK)
+	wantStatus(t, anon.post("/reset-password", form), http.StatusBadRequest)
+
+	// The reset signs the user out everywhere
+	wantStatus(t, old.get("/home"), http.StatusUnauthorized)
+	if _, err := m.PasswordResetUser(token); err != ErrInvalidToken {
+		t.Errorf("used token: err = %v, want ErrInvalidToken", err)
+	}
+	anon.login("alice", "battery staple 2")
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// voteTargets maps the interaction tables to the column holding the voted-on ID.
+// Only these tables may be passed to vote/userVote, which keeps the
+// table/column names safe to format into queries.
+var voteTargets = map[string]string{
+	"interactions":         "post_id",
+	"comment_interactions": "comment_id",
+}
+
+// vote applies a like or dislike from a user to a post or comment.
+// Re-submitting the active vote clears it; submitting the opposite vote
+// replaces it. The read and write happen in one transaction so two
+// concurrent clicks can't leave the user with more than one row.
+// Returns the user's vote state after the change.
+func (db *DataBase) vote(table, uuid string, targetID int, kind VoteKind) (VoteKind, error) {
+	column, ok := voteTargets[table]
+	if !ok {
+		return VoteNone, fmt.Errorf("unknown vote table %q", table)
+	}
+	if kind != VoteLike && kind != VoteDislike {
+		return VoteNone, errors.New("vote must be a like or a dislike")
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return VoteNone, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanVote(tx.QueryRow(
+		fmt.Sprintf("SELECT liked, disliked FROM %s WHERE user_uuid = ? AND %s = ?", table, column),
+		uuid, targetID,
+	))
+	if err != nil {
+		return VoteNone, err
+	}
+
+	next := kind
+	if current == kind {
+		// Clicking the active vote again retracts it
+		next = VoteNone
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_uuid = ? AND %s = ?", table, column), uuid, targetID)
+	} else {
+		now := sessionTime(time.Now())
+		_, err = tx.Exec(fmt.Sprintf(`
+            INSERT INTO %[1]s (user_uuid, %[2]s, liked, disliked, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+            ON CONFLICT(user_uuid, %[2]s) DO UPDATE SET liked = excluded.liked, disliked = excluded.disliked, updated_at = excluded.updated_at
+        `, table, column), uuid, targetID, kind == VoteLike, kind == VoteDislike, now, now)
+	}
+	if err != nil {
+		return VoteNone, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return VoteNone, err
+	}
+	return next, nil
+}
+
+// userVote returns the current vote a user has on a post or comment.
+func (db *DataBase) userVote(table, uuid string, targetID int) (VoteKind, error) {
+	column, ok := voteTargets[table]
+	if !ok {
+		return VoteNone, fmt.Errorf("unknown vote table %q", table)
+	}
+	return scanVote(db.Conn.QueryRow(
+		fmt.Sprintf("SELECT liked, disliked FROM %s WHERE user_uuid = ? AND %s = ?", table, column),
+		uuid, targetID,
+	))
+}
+
+// VotePost toggles a user's like or dislike on a post.
+func (db *DataBase) VotePost(uuid string, postID int, kind VoteKind) (VoteKind, error) {
+	return db.vote("interactions", uuid, postID, kind)
+}
+
+// VoteComment toggles a user's like or dislike on a comment.
+func (db *DataBase) VoteComment(uuid string, commentID int, kind VoteKind) (VoteKind, error) {
+	return db.vote("comment_interactions", uuid, commentID, kind)
+}
+
+// PostVote returns the user's current vote on a post.
+func (db *DataBase) PostVote(uuid string, postID int) (VoteKind, error) {
+	return db.userVote("interactions", uuid, postID)
+}
+
+// CommentVotes returns the user's votes on the comments of a post.
+func (db *DataBase) CommentVotes(uuid string, postID int) (map[int]VoteKind, error) {
+	rows, err := db.Conn.Query(`
+        SELECT comment_interactions.comment_id, comment_interactions.liked
+        FROM comment_interactions
+        JOIN comments ON comment_interactions.comment_id = comments.id
+        WHERE comment_interactions.user_uuid = ? AND comments.post_id = ?
+    `, uuid, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := make(map[int]VoteKind)
+	for rows.Next() {
+		var commentID int
+		var liked bool
+		if err := rows.Scan(&commentID, &liked); err != nil {
+			return nil, err
+		}
+		if liked {
+			votes[commentID] = VoteLike
+		} else {
+			votes[commentID] = VoteDislike
+		}
+	}
+	return votes, rows.Err()
+}
+
+// scanVote converts a (liked, disliked) row into a VoteKind.
+func scanVote(row *sql.Row) (VoteKind, error) {
+	var liked, disliked bool
+	err := row.Scan(&liked, &disliked)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return VoteNone, nil
+	case err != nil:
+		return VoteNone, err
+	case liked:
+		return VoteLike, nil
+	case disliked:
+		return VoteDislike, nil
+	}
+	return VoteNone, nil
+}
+
+// handlePostVote is shared by LikeHandler and DislikeHandler,
+// which are wrapped in RequireRegistered.
+func handlePostVote(w http.ResponseWriter, r *http.Request, kind VoteKind) {
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uuid := CurrentUser(r).UUID
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		RenderError(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+
+	post, err := store.GetPost(postID)
+	if err != nil {
+		RenderError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if post.Deleted {
+		RenderError(w, "This post has been deleted", http.StatusGone)
+		return
+	}
+
+	if _, err := store.VotePost(uuid, postID, kind); err != nil {
+		RenderError(w, "Failed to "+kind.Verb()+" post", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
+}
+
+// handleCommentVote is shared by CommentLikeHandler and CommentDislikeHandler,
+// which are wrapped in RequireRegistered.
+func handleCommentVote(w http.ResponseWriter, r *http.Request, kind VoteKind) {
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uuid := CurrentUser(r).UUID
+
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		RenderError(w, "Missing comment ID", http.StatusBadRequest)
+		return
+	}
+
+	// Find the post the comment belongs to so we can redirect back to it
+	comment, err := store.GetComment(commentID)
+	if err != nil {
+		RenderError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if gone, err := commentGone(comment); err != nil {
+		RenderError(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	} else if gone != "" {
+		RenderError(w, gone, http.StatusGone)
+		return
+	}
+
+	if _, err := store.VoteComment(uuid, commentID, kind); err != nil {
+		RenderError(w, "Failed to "+kind.Verb()+" comment", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", comment.Post.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// JanitorInterval is how often the janitor cleans up.
+var JanitorInterval = 10 * time.Minute
+
+// SessionRetention is how long ended sessions and used or expired reset
+// links are kept, e.g. so /login can still explain a replaced session.
+var SessionRetention = 24 * time.Hour
+
+// PurgeStats counts what one cleanup pass removed.
+type PurgeStats struct {
+	Guests         int64
+	Sessions       int64
+	PasswordResets int64
+	ThrottleKeys   int64
+}
+
+// Total is the number of rows removed.
+func (s PurgeStats) Total() int64 {
+	return s.Guests + s.Sessions + s.PasswordResets + s.ThrottleKeys
+}
+
+func (s PurgeStats) String() string {
+	return fmt.Sprintf("%s, %s, %s, %s",
+		plural(int(s.Guests), "guest"), plural(int(s.Sessions), "session"),
+		plural(int(s.PasswordResets), "password reset"), plural(int(s.ThrottleKeys), "throttle key"))
+}
+
+// abandonedGuests selects guests with no live session that haven't been seen
+// for a SessionTimeout. Guests that somehow own content are left alone.
+// lastseen is compared with julianday since older rows carry local offsets.
+const abandonedGuests = `
+    SELECT uuid FROM users
+    WHERE notregistered = 1 AND julianday(lastseen) < julianday(?1)
+      AND NOT EXISTS (SELECT 1 FROM sessions WHERE sessions.user_uuid = users.uuid AND revoked_at IS NULL AND expires_at > ?2)
+      AND NOT EXISTS (SELECT 1 FROM posts WHERE author_uuid = users.uuid)
+      AND NOT EXISTS (SELECT 1 FROM comments WHERE comment_author_uuid = users.uuid)
+      AND NOT EXISTS (SELECT 1 FROM replies WHERE reply_author_uuid = users.uuid)
+      AND NOT EXISTS (SELECT 1 FROM subforums WHERE creator_uuid = users.uuid)
+`
+
+// PurgeExpired removes abandoned guest accounts, sessions that ended more than
+// SessionRetention ago, old password reset links and forgotten throttle keys.
+func (db *DataBase) PurgeExpired(now time.Time) (PurgeStats, error) {
+	var stats PurgeStats
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	current := sessionTime(now)
+	retained := sessionTime(now.Add(-SessionRetention))
+
+	// Guests first, children before parents
+	guests := "(" + abandonedGuests + ")"
+	args := []interface{}{sessionTime(now.Add(-SessionTimeout)), current}
+	statements := []string{
+		"DELETE FROM interactions WHERE user_uuid IN " + guests,
+		"DELETE FROM comment_interactions WHERE user_uuid IN " + guests,
+		"DELETE FROM session_events WHERE user_uuid IN " + guests,
+		"DELETE FROM sessions WHERE user_uuid IN " + guests,
+		"DELETE FROM password_resets WHERE user_uuid IN " + guests,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return stats, err
+		}
+	}
+	if stats.Guests, err = execCount(tx, "DELETE FROM users WHERE uuid IN "+guests, args...); err != nil {
+		return stats, err
+	}
+
+	// Audit events outlive the sessions they mention
+	endedSessions := "SELECT id FROM sessions WHERE expires_at < ?1 OR revoked_at < ?1"
+	if _, err := tx.Exec("UPDATE session_events SET session_id = NULL WHERE session_id IN ("+endedSessions+")", retained); err != nil {
+		return stats, err
+	}
+	if stats.Sessions, err = execCount(tx, "DELETE FROM sessions WHERE id IN ("+endedSessions+")", retained); err != nil {
+		return stats, err
+	}
+
+	if stats.PasswordResets, err = execCount(tx, "DELETE FROM password_resets WHERE expires_at < ?1 OR used_at < ?1", retained); err != nil {
+		return stats, err
+	}
+
+	stats.ThrottleKeys, err = execCount(tx, `
+        DELETE FROM auth_throttle
+        WHERE last_failure < ? AND (locked_until IS NULL OR locked_until < ?)
+    `, sessionTime(now.Add(-AuthThrottle.Window)), current)
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, tx.Commit()
+}
+
+// execCount runs a statement and returns how many rows it changed.
+func execCount(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// StartJanitor runs PurgeExpired now and then every interval until ctx is
+// cancelled. The returned channel is closed once the janitor has stopped, so
+// shutdown can wait for a pass in progress to finish.
+func (db *DataBase) StartJanitor(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var total PurgeStats
+		for {
+			stats, err := db.PurgeExpired(time.Now())
+			switch {
+			case err != nil:
+				log.Println("Janitor: cleanup failed:", err)
+			case stats.Total() > 0:
+				total.Guests += stats.Guests
+				total.Sessions += stats.Sessions
+				total.PasswordResets += stats.PasswordResets
+				total.ThrottleKeys += stats.ThrottleKeys
+				log.Printf("Janitor: removed %s (since start: %s)", stats, total)
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Printf("Janitor: stopped after removing %s", total)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countRows returns how many rows of table match where.
+func countRows(t *testing.T, d *DataBase, table, where string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := d.Conn.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+where, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// seedJanitor fills d with rows on either side of every limit PurgeExpired
+// applies at now. Rows named "gone" should be purged, "kept" ones not.
+func seedJanitor(t *testing.T, d *DataBase, now time.Time) {
+	t.Helper()
+	at := func(d time.Duration) string { return sessionTime(now.Add(d)) }
+	old := at(-SessionRetention - time.Hour)
+	stale := at(-SessionTimeout - time.Hour)
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO users (uuid, username, email, password, notregistered, lastseen) VALUES
+            ('alice-uuid', 'alice', 'alice@example.com', '', 0, ?1),
+            ('gone-guest', 'guest_1', '', '', 1, ?1),
+            ('live-guest', 'guest_2', '', '', 1, ?1),
+            ('fresh-guest', 'guest_3', '', '', 1, ?2),
+            ('author-guest', 'guest_4', '', '', 1, ?1)`, []interface{}{stale, at(-time.Minute)}},
+		{"INSERT INTO posts (title, content, author_uuid, created_at, updated_at) VALUES ('Title', 'text', 'author-guest', ?1, ?1)", []interface{}{old}},
+		{"INSERT INTO interactions (user_uuid, post_id, liked, created_at, updated_at) VALUES ('gone-guest', 1, 1, ?1, ?1)", []interface{}{old}},
+		{`INSERT INTO sessions (token_hash, user_uuid, created_at, expires_at, ip, user_agent, revoked_at, revoked_reason) VALUES
+            ('gone-guest', 'gone-guest', ?1, ?1, '', '', NULL, NULL),
+            ('live-guest', 'live-guest', ?1, ?2, '', '', NULL, NULL),
+            ('gone-expired', 'alice-uuid', ?1, ?1, '', '', NULL, NULL),
+            ('gone-revoked', 'alice-uuid', ?1, ?2, '', '', ?1, 'logout'),
+            ('kept-revoked', 'alice-uuid', ?1, ?2, '', '', ?3, 'replaced'),
+            ('kept-live', 'alice-uuid', ?1, ?2, '', '', NULL, NULL)`, []interface{}{old, at(time.Hour), at(-time.Hour)}},
+		{`INSERT INTO session_events (user_uuid, session_id, event, ip, user_agent, created_at) VALUES
+            ('gone-guest', 1, 'login', '', '', ?1),
+            ('alice-uuid', 3, 'replaced', '', '', ?1)`, []interface{}{old}},
+		{`INSERT INTO password_resets (user_uuid, token_hash, created_at, expires_at, used_at, ip) VALUES
+            ('alice-uuid', 'gone-used', ?1, ?2, ?1, ''),
+            ('alice-uuid', 'gone-expired', ?1, ?1, NULL, ''),
+            ('alice-uuid', 'kept-used', ?1, ?2, ?3, ''),
+            ('alice-uuid', 'kept-open', ?1, ?2, NULL, '')`, []interface{}{old, at(time.Hour), at(-time.Hour)}},
+		{`INSERT INTO auth_throttle (key, failures, last_failure, locked_until) VALUES
+            ('gone:stale', 3, ?1, NULL),
+            ('gone:unlocked', 9, ?1, ?1),
+            ('kept:locked', 9, ?1, ?2),
+            ('kept:recent', 1, ?3, NULL)`, []interface{}{at(-AuthThrottle.Window - time.Hour), at(time.Hour), at(-time.Minute)}},
+	}
+	for _, s := range statements {
+		if _, err := d.Conn.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("%v\n%s", err, s.query)
+		}
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	d := openTestDB(t)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	seedJanitor(t, d, now)
+
+	stats, err := d.PurgeExpired(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PurgeStats{Guests: 1, Sessions: 2, PasswordResets: 2, ThrottleKeys: 2}
+	if stats != want {
+		t.Errorf("PurgeExpired = %s, want %s", stats, want)
+	}
+
+	if n := countRows(t, d, "users", "uuid = 'gone-guest'"); n != 0 {
+		t.Error("abandoned guest kept")
+	}
+	for _, uuid := range []string{"alice-uuid", "live-guest", "fresh-guest", "author-guest"} {
+		if n := countRows(t, d, "users", "uuid = ?", uuid); n != 1 {
+			t.Errorf("user %s purged", uuid)
+		}
+	}
+	if n := countRows(t, d, "interactions", "user_uuid = 'gone-guest'") + countRows(t, d, "session_events", "user_uuid = 'gone-guest'"); n != 0 {
+		t.Errorf("%d rows of the abandoned guest left", n)
+	}
+
+	for table, column := range map[string]string{"sessions": "token_hash", "password_resets": "token_hash", "auth_throttle": "key"} {
+		if n := countRows(t, d, table, column+" LIKE 'gone%'"); n != 0 {
+			t.Errorf("%d expired rows left in %s", n, table)
+		}
+		if n := countRows(t, d, table, column+" LIKE 'kept%'"); n != 2 {
+			t.Errorf("%d live rows left in %s, want 2", n, table)
+		}
+	}
+	if n := countRows(t, d, "session_events", "user_uuid = 'alice-uuid' AND session_id IS NULL"); n != 1 {
+		t.Error("the event of a purged session wasn't kept without it")
+	}
+
+	// Nothing is left to purge
+	if stats, err := d.PurgeExpired(now); err != nil || stats.Total() != 0 {
+		t.Errorf("second pass = %s, %v; want nothing", stats, err)
+	}
+}
+
+func TestStartJanitor(t *testing.T) {
+	d := openTestDB(t)
+	seedJanitor(t, d, time.Now())
+
+	// The first pass runs straight away, before the janitor notices ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	select {
+	case <-d.StartJanitor(ctx, time.Hour):
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor didn't stop")
+	}
+
+	var gone []string
+	for table, column := range map[string]string{"sessions": "token_hash", "password_resets": "token_hash", "auth_throttle": "key"} {
+		if n := countRows(t, d, table, column+" LIKE 'gone%'"); n != 0 {
+			gone = append(gone, table)
+		}
+	}
+	if countRows(t, d, "users", "uuid = 'gone-guest'") != 0 {
+		gone = append(gone, "users")
+	}
+	if len(gone) > 0 {
+		t.Errorf("janitor left expired rows in %s", strings.Join(gone, ", "))
+	}
+}
+
+func TestDeleteGuest(t *testing.T) {
+	d := openTestDB(t)
+	now := time.Now()
+	seedJanitor(t, d, now)
+
+	if err := d.DeleteUser("alice-uuid"); err == nil {
+		t.Error("deleting a registered user as a guest succeeded")
+	}
+	if n := countRows(t, d, "sessions", "user_uuid = 'alice-uuid'"); n != 4 {
+		t.Errorf("%d sessions left after refusing to delete alice, want 4", n)
+	}
+
+	if err := d.DeleteUser("gone-guest"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"interactions", "sessions", "session_events"} {
+		if n := countRows(t, d, table, "user_uuid = 'gone-guest'"); n != 0 {
+			t.Errorf("%d rows of the deleted guest left in %s", n, table)
+		}
+	}
+	if n := countRows(t, d, "session_events", "user_uuid = 'alice-uuid'"); n != 1 {
+		t.Errorf("%d of alice's session events left, want 1", n)
+	}
+	if err := d.DeleteUser("gone-guest"); err == nil {
+		t.Error("deleting the guest twice succeeded")
+	}
+}
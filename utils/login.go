@@ -3,86 +3,57 @@ package utils
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned by Login for an unknown user or a wrong password.
+var ErrInvalidCredentials = errors.New("invalid username, email, or password")
+
 // Login checks if a user exists and optionally registers them.
 // Returns the fully populated User struct.
-func (db *DataBase) Login(w http.ResponseWriter, r *http.Request, username, email, password string) (User, error) {
-	var user User
-
-	// 1. Check if cookie already corresponds to a logged-in user
-	uuid, err := GetUserFromCookie(r) // nil == cookie exists
-	if err == nil && uuid != "" {
-		var alreadyLoggedIn bool
-		err := db.Conn.QueryRow("SELECT loggedin FROM users WHERE uuid = ?", uuid).Scan(&alreadyLoggedIn)
-		if err == nil && alreadyLoggedIn {
-			return User{}, errors.New("user already logged in")
-		}
+func Login(w http.ResponseWriter, r *http.Request, username, email, password string) (User, error) {
+	// 1. Check if cookie already corresponds to a live session
+	if _, err := store.SessionByToken(GetSessionToken(r)); err == nil {
+		return User{}, errors.New("user already logged in")
 	}
 
-	// 2. Query the user by username or email
-	row := db.Conn.QueryRow(
-		"SELECT uuid, username, email, password, notregistered, loggedin FROM users WHERE username = ? OR email = ?",
-		username, email,
-	)
-
-	// Scan the result into the User struct
-	errScan := row.Scan(&user.UUID, &user.Username, &user.Email, &user.Password, &user.NotRegistered, &user.LoggedIn)
-	if errScan != nil {
-		if errScan == sql.ErrNoRows {
-			return User{}, errors.New("user not found")
+	// 2. Query the user by username or email, ignoring case
+	user, err := store.FindUser(username, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, fmt.Errorf("user not found: %w", ErrInvalidCredentials)
 		}
-		log.Println("Error scanning user:", errScan)
-		return User{}, errScan
-	}
-
-	// 3. Prevent login if user is already logged in
-	if user.LoggedIn {
-		return User{}, errors.New("this user is already logged in from another session")
-	}
-
-	// 4. Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return User{}, errors.New("invalid password")
-	}
-
-	// 5. Refresh session & mark user as logged in
-	if err := db.RefreshSession(user.UUID); err != nil {
+		log.Println("Error scanning user:", err)
 		return User{}, err
 	}
 
-	_, err = db.Conn.Exec("UPDATE users SET loggedin = 1 WHERE uuid = ?", user.UUID)
-	if err != nil {
-		return User{}, err
+	// 3. Verify password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return User{}, fmt.Errorf("invalid password: %w", ErrInvalidCredentials)
 	}
 
-	// Update the struct too
-	user.LoggedIn = true
-
 	// Login successful
-	return user, nil
+	return *user, nil
 }
 
-// Logout logs the user out by clearing session and updating DB.
-func (db *DataBase) Logout(w http.ResponseWriter, r *http.Request) {
-	uuid, err := GetUserFromCookie(r)
-	if err != nil || uuid == "" {
+// Logout revokes the session server-side and clears the cookie.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	token := GetSessionToken(r)
+	if token == "" {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	// Set loggedin = 0
-	_, err = db.Conn.Exec("UPDATE users SET loggedin = 0 WHERE uuid = ?", uuid)
-	if err != nil {
+	// Revoke the token so a copied cookie stops working too
+	if err := store.RevokeSession(token, RevokeLogout); err != nil {
 		http.Error(w, "Failed to log out", http.StatusInternalServerError)
 		return
 	}
 
-	
 	ClearUserCookie(w)
 
 	// Redirect to login
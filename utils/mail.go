@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail is a plain-text email.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MailSender delivers mail. Swap Mailer with SetMailer to use a real provider.
+type MailSender interface {
+	Send(msg Mail) error
+}
+
+// OutboxSender writes each message to its own file in Dir instead of sending
+// it, so sign-up works offline and messages can be read back in tests.
+type OutboxSender struct {
+	Dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+// Send writes msg to Dir as an .eml file.
+func (o *OutboxSender) Send(msg Mail) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := os.MkdirAll(o.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create outbox: %w", err)
+	}
+
+	now := time.Now()
+	o.n++
+	name := fmt.Sprintf("%s-%03d-%s.eml", now.Format("20060102T150405"), o.n, outboxSafe(msg.To))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	return os.WriteFile(filepath.Join(o.Dir, name), []byte(b.String()), 0o600)
+}
+
+// outboxSafe keeps an address usable as part of a file name.
+func outboxSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Mailer sends the forum's emails; by default into ./outbox.
+var Mailer MailSender = &OutboxSender{Dir: "outbox"}
+
+// SetMailer replaces the sender used for outgoing mail.
+func SetMailer(m MailSender) {
+	Mailer = m
+}
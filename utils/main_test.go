@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestMain runs the tests from the repository root, where the templates
+// live, and keeps mail and logs out of the way.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		log.Fatal(err)
+	}
+	SetMailer(&testMailer{})
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testMailer keeps sent mail in memory.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (m *testMailer) Send(msg Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// lastMail returns the most recent message sent to addr.
+func lastMail(t *testing.T, addr string) Mail {
+	t.Helper()
+	m := Mailer.(*testMailer)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == addr {
+			return m.sent[i]
+		}
+	}
+	t.Fatalf("no mail sent to %s", addr)
+	return Mail{}
+}
+
+// useStore points the handlers at s for the rest of the test.
+func useStore(t *testing.T, s Store) {
+	t.Helper()
+	old := store
+	SetStore(s)
+	t.Cleanup(func() { SetStore(old) })
+}
+
+// openTestDB migrates a fresh SQLite database in a temporary directory.
+// The handlers use it until the test ends. The test is skipped when SQLite
+// was built without FTS5, which the schema needs.
+func openTestDB(t *testing.T) *DataBase {
+	t.Helper()
+	oldDB, oldStore := db, store
+	t.Cleanup(func() {
+		if db != nil && db != oldDB {
+			db.Conn.Close()
+		}
+		db, store = oldDB, oldStore
+	})
+	d, err := DBInitialize(filepath.Join(t.TempDir(), "forum"))
+	if errors.Is(err, ErrNoFTS5) {
+		t.Skip("SQLite lacks FTS5; run the tests with -tags sqlite_fts5 (make test)")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// testStores runs fn against a MemoryStore and against SQLite, so both
+// backends are held to the same behaviour.
+func testStores(t *testing.T, fn func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		m := NewMemoryStore()
+		useStore(t, m)
+		fn(t, m)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		fn(t, openTestDB(t))
+	})
+}
+
+// newTestServer routes requests the way main does.
+func newTestServer() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", DefaultHandler)
+	mux.HandleFunc("/home", RequireGuestOrUser(HomeHandler))
+	mux.HandleFunc("/login", LoginHandler)
+	mux.HandleFunc("/logout", LogoutHandler)
+	mux.HandleFunc("/guest", GuestHandler)
+	mux.HandleFunc("/register", RegisterHandler)
+	mux.HandleFunc("/verify", VerifyHandler)
+	mux.HandleFunc("/forgot-password", ForgotPasswordHandler)
+	mux.HandleFunc("/reset-password", ResetPasswordHandler)
+	mux.HandleFunc("/settings", RequireGuestOrUser(SettingsHandler))
+	mux.HandleFunc("/settings/export", RequireGuestOrUser(ExportHandler))
+	mux.HandleFunc("/settings/delete", RequireGuestOrUser(DeleteAccountHandler))
+	mux.HandleFunc("/create-post", RequireRegistered(CreatePostHandler))
+	mux.HandleFunc("/post/", PostHandler)
+	mux.HandleFunc("/like", RequireRegistered(LikeHandler))
+	mux.HandleFunc("/comment/like", RequireRegistered(CommentLikeHandler))
+	mux.HandleFunc("/search", SearchHandler)
+	mux.HandleFunc("/create-forum", RequireRegistered(CreateSubForumHandler))
+	mux.HandleFunc("/f/", SubForumHandler)
+	mux.HandleFunc(APIPrefix, APIHandler)
+	return Authenticate(CSRFProtect(mux))
+}
+
+// testClient sends requests to a handler as one browser would, keeping
+// the session cookie and adding the CSRF token to forms.
+type testClient struct {
+	t       *testing.T
+	handler http.Handler
+	token   string
+	csrf    string
+	addr    string // the client's RemoteAddr; httptest's default when empty
+}
+
+// newTestUser stores a registered, verified user with the given password
+// and returns a client logged in as them.
+func newTestUser(t *testing.T, h http.Handler, username, password string) (*testClient, *User) {
+	t.Helper()
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{
+		UUID:          uuid.NewString(),
+		Username:      username,
+		Email:         username + "@example.com",
+		Password:      hash,
+		EmailVerified: true,
+	}
+	if err := store.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, handler: h}
+	c.login(user.Username, password)
+	return c, &user
+}
+
+// login signs the client in through /login.
+func (c *testClient) login(username, password string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	rec := c.post("/login", url.Values{"username": {username}, "password": {password}})
+	if rec.Code != http.StatusSeeOther {
+		c.t.Fatalf("login as %s: status %d: %s", username, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+func (c *testClient) get(target string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.do(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+// post submits a form, with the session's CSRF token unless form already has one.
+func (c *testClient) post(target string, form url.Values) *httptest.ResponseRecorder {
+	c.t.Helper()
+	form = cloneValues(form)
+	if c.csrf != "" && !form.Has(CSRFFieldName) {
+		form.Set(CSRFFieldName, c.csrf)
+	}
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+// cloneValues copies a form so adding the CSRF token leaves the caller's untouched.
+func cloneValues(form url.Values) url.Values {
+	clone := url.Values{}
+	for key, values := range form {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// postJSON calls the JSON API.
+func (c *testClient) postJSON(target, body string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+func (c *testClient) do(req *http.Request) *httptest.ResponseRecorder {
+	c.t.Helper()
+	if c.addr != "" {
+		req.RemoteAddr = c.addr
+	}
+	if c.token != "" {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.token})
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name != SessionCookieName {
+			continue
+		}
+		if cookie.Value != c.token {
+			c.token = cookie.Value
+			c.csrf = ""
+			if session, err := store.SessionByToken(c.token); err == nil {
+				c.csrf = session.CSRFToken
+			}
+		}
+	}
+	return rec
+}
+
+// wantStatus fails the test unless rec has the given status.
+func wantStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, bodySummary(rec.Body.String()))
+	}
+}
+
+// bodySummary picks the message out of an error page, or shortens other bodies.
+func bodySummary(body string) string {
+	if _, msg, ok := strings.Cut(body, "<strong>Message:</strong>"); ok {
+		msg, _, _ = strings.Cut(msg, "</p>")
+		return strings.TrimSpace(msg)
+	}
+	if len(body) > 300 {
+		return body[:300] + "…"
+	}
+	return body
+}
+
+// wantBody fails the test unless rec's body contains every one of parts.
+func wantBody(t *testing.T, rec *httptest.ResponseRecorder, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(rec.Body.String(), part) {
+			t.Fatalf("body does not contain %q: %s", part, bodySummary(rec.Body.String()))
+		}
+	}
+}
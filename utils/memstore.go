@@ -0,0 +1,1487 @@
+package utils
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryStore is an in-memory Store so handlers can be exercised without SQLite.
+// It follows the same rules as the SQLite implementation: newest posts and
+// comments first, toggling votes, and sql.ErrNoRows for missing rows.
+type MemoryStore struct {
+	mu           sync.Mutex
+	nextID       int
+	users        map[string]User
+	posts        map[int]*memPost
+	categories   []Category
+	comments     map[int]*memComment
+	replies      []memReply
+	postVotes    map[memVoteKey]VoteKind
+	commentVotes map[memVoteKey]VoteKind
+	revisions    []memRevision
+	sessions     []*memSession
+	events       []string
+	forums       map[int]*memForum
+	secrets      map[string][]byte
+	resets       map[string]*memReset
+	throttle     map[string]throttleEntry
+}
+
+type memPost struct {
+	id         int
+	title      string
+	content    string
+	authorUUID string
+	categories []string
+	createdAt  time.Time
+	updatedAt  time.Time
+	editedAt   *time.Time
+	deleted    bool
+}
+
+type memComment struct {
+	id         int
+	postID     int
+	authorUUID string
+	content    string
+	createdAt  time.Time
+	updatedAt  time.Time
+	editedAt   *time.Time
+	deleted    bool
+}
+
+type memRevision struct {
+	Revision
+	editorUUID string
+}
+
+type memReply struct {
+	id         int
+	commentID  int
+	parentID   int
+	depth      int
+	authorUUID string
+	content    string
+	createdAt  time.Time
+}
+
+type memSession struct {
+	Session
+	tokenHash     string
+	revokedReason string
+}
+
+type memForum struct {
+	SubForum
+	admins []string
+	// posts maps the IDs of posts filed under the forum to whether they are pinned.
+	posts map[int]bool
+}
+
+type memReset struct {
+	userUUID  string
+	expiresAt time.Time
+	used      bool
+}
+
+type memVoteKey struct {
+	uuid string
+	id   int
+}
+
+// NewMemoryStore returns a MemoryStore holding just the tombstone account.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:        map[string]User{DeletedUserUUID: {UUID: DeletedUserUUID, Username: "deleted"}},
+		posts:        make(map[int]*memPost),
+		comments:     make(map[int]*memComment),
+		postVotes:    make(map[memVoteKey]VoteKind),
+		commentVotes: make(map[memVoteKey]VoteKind),
+		forums:       make(map[int]*memForum),
+		secrets:      make(map[string][]byte),
+		resets:       make(map[string]*memReset),
+		throttle:     make(map[string]throttleEntry),
+	}
+}
+
+func (m *MemoryStore) newID() int {
+	m.nextID++
+	return m.nextID
+}
+
+// --- UserStore ---
+
+func (m *MemoryStore) UserByUUID(uuid string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[uuid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user, nil
+}
+
+func (m *MemoryStore) FindUser(username, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if sameFold(user.Username, username) || sameFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) CreateUser(user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.UUID]; ok {
+		return errors.New("UNIQUE constraint failed: users.uuid")
+	}
+	for _, other := range m.users {
+		if sameFold(other.Username, user.Username) {
+			return ErrUsernameTaken
+		}
+		if sameFold(other.Email, user.Email) {
+			return ErrEmailTaken
+		}
+	}
+	m.users[user.UUID] = user
+	return nil
+}
+
+func (m *MemoryStore) UpdateUser(user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.users[user.UUID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for uuid, other := range m.users {
+		if uuid == user.UUID {
+			continue
+		}
+		if sameFold(other.Username, user.Username) {
+			return ErrUsernameTaken
+		}
+		if sameFold(other.Email, user.Email) {
+			return ErrEmailTaken
+		}
+	}
+	if !sameFold(old.Email, user.Email) {
+		for _, reset := range m.resets {
+			if reset.userUUID == user.UUID {
+				reset.used = true
+			}
+		}
+	}
+	m.users[user.UUID] = user
+	return nil
+}
+
+func (m *MemoryStore) UpgradeGuest(uuid, username, email, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[uuid]
+	if !ok || !user.NotRegistered {
+		return nil, ErrNotGuest
+	}
+	for other, u := range m.users {
+		if other == uuid {
+			continue
+		}
+		if sameFold(u.Username, username) {
+			return nil, ErrUsernameTaken
+		}
+		if sameFold(u.Email, email) {
+			return nil, ErrEmailTaken
+		}
+	}
+	user = User{UUID: uuid, Username: username, Email: email, Password: hash, Lastseen: time.Now()}
+	m.users[uuid] = user
+	return &user, nil
+}
+
+func (m *MemoryStore) MarkEmailVerified(uuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[uuid]; ok {
+		user.EmailVerified = true
+		m.users[uuid] = user
+	}
+	return nil
+}
+
+// DeleteUser removes a guest account and its sessions, like DataBase.DeleteUser.
+func (m *MemoryStore) DeleteUser(uuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[uuid]; !ok || !user.NotRegistered {
+		return errors.New("no guest user found with the provided UUID")
+	}
+	delete(m.users, uuid)
+	kept := m.sessions[:0]
+	for _, s := range m.sessions {
+		if s.UserUUID != uuid {
+			kept = append(kept, s)
+		}
+	}
+	m.sessions = kept
+	return nil
+}
+
+// --- PostStore ---
+
+func (m *MemoryStore) ListPosts(q PostQuery, page Page) ([]Post, PageInfo, error) {
+	keys, order := postKeyset(q.Sort)
+	cur, err := keys.decode(page.Cursor)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var posts []Post
+	for _, p := range m.posts {
+		if p.deleted {
+			continue
+		}
+		if q.Category != "" && !containsString(p.categories, q.Category) {
+			continue
+		}
+		if q.AuthorUUID != "" && p.authorUUID != q.AuthorUUID {
+			continue
+		}
+		if q.LikedBy != "" && m.postVotes[memVoteKey{q.LikedBy, p.id}] != VoteLike {
+			continue
+		}
+		posts = append(posts, m.post(p))
+	}
+	key := func(p Post) []interface{} { return postKey(order, p) }
+	sort.Slice(posts, func(i, j int) bool { return compareKeys(key(posts[i]), key(posts[j])) > 0 })
+	posts, info := pageOf(keys, seek(posts, page.size(), cur, key), page.size(), cur, key)
+	return posts, info, nil
+}
+
+func (m *MemoryStore) GetPost(postID int) (*Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.posts[postID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	post := m.post(p)
+	return &post, nil
+}
+
+// post builds the Post view of a stored post; m.mu must be held.
+func (m *MemoryStore) post(p *memPost) Post {
+	post := Post{
+		ID:         p.id,
+		Title:      p.title,
+		Content:    p.content,
+		Author:     m.author(p.authorUUID),
+		Categories: []Category{},
+		CreatedAt:  p.createdAt,
+		UpdatedAt:  p.updatedAt,
+		ActiveAt:   p.createdAt,
+		EditedAt:   p.editedAt,
+		Deleted:    p.deleted,
+	}
+	post.Author.UUID = p.authorUUID
+	if p.deleted {
+		post.Author.Username = "deleted"
+	}
+	for _, name := range p.categories {
+		post.Categories = append(post.Categories, Category{Name: name})
+	}
+	for _, c := range m.comments {
+		if c.postID == p.id {
+			post.CommentCount++
+			if c.createdAt.After(post.ActiveAt) {
+				post.ActiveAt = c.createdAt
+			}
+		}
+	}
+	for _, r := range m.replies {
+		if c, ok := m.comments[r.commentID]; ok && c.postID == p.id && r.createdAt.After(post.ActiveAt) {
+			post.ActiveAt = r.createdAt
+		}
+	}
+	for key, vote := range m.postVotes {
+		if key.id != p.id {
+			continue
+		}
+		if vote == VoteLike {
+			post.LikeCount++
+		} else if vote == VoteDislike {
+			post.DislikeCount++
+		}
+	}
+	return post
+}
+
+// author returns the public part of a user; m.mu must be held.
+func (m *MemoryStore) author(uuid string) User {
+	return User{Username: m.users[uuid].Username}
+}
+
+// CreatePost stores a post, filing it under forum when one is given.
+func (m *MemoryStore) CreatePost(uuid, title, content string, categories []string, forum *SubForum) (int, error) {
+	if strings.TrimSpace(title) == "" || strings.TrimSpace(content) == "" {
+		return 0, ErrEmptyPost
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[uuid]; !ok {
+		return 0, errors.New("FOREIGN KEY constraint failed")
+	}
+
+	now := time.Now()
+	p := &memPost{id: m.newID(), title: title, content: content, authorUUID: uuid, createdAt: now, updatedAt: now}
+	for _, cat := range categories {
+		cat = strings.TrimSpace(cat)
+		if cat == "" || containsString(p.categories, cat) {
+			continue
+		}
+		p.categories = append(p.categories, cat)
+
+		known := false
+		for _, c := range m.categories {
+			if c.Name == cat {
+				known = true
+				break
+			}
+		}
+		if !known {
+			m.categories = append(m.categories, Category{ID: m.newID(), Name: cat})
+		}
+	}
+	if forum != nil {
+		f, ok := m.forums[forum.ID]
+		if !ok {
+			return 0, errors.New("FOREIGN KEY constraint failed")
+		}
+		f.posts[p.id] = false
+	}
+	m.posts[p.id] = p
+	return p.id, nil
+}
+
+// deletePostLocked removes a post with its comments, votes and sub-forum links; m.mu must be held.
+func (m *MemoryStore) deletePostLocked(postID int) {
+	for id, c := range m.comments {
+		if c.postID != postID {
+			continue
+		}
+		m.deleteCommentLocked(id)
+	}
+	for key := range m.postVotes {
+		if key.id == postID {
+			delete(m.postVotes, key)
+		}
+	}
+	for _, f := range m.forums {
+		delete(f.posts, postID)
+	}
+	m.dropRevisionsLocked(RevisionPost, postID)
+	delete(m.posts, postID)
+}
+
+// deleteCommentLocked removes a comment with its replies and votes; m.mu must be held.
+func (m *MemoryStore) deleteCommentLocked(commentID int) {
+	kept := m.replies[:0]
+	for _, r := range m.replies {
+		if r.commentID != commentID {
+			kept = append(kept, r)
+		}
+	}
+	m.replies = kept
+	for key := range m.commentVotes {
+		if key.id == commentID {
+			delete(m.commentVotes, key)
+		}
+	}
+	m.dropRevisionsLocked(RevisionComment, commentID)
+	delete(m.comments, commentID)
+}
+
+// dropRevisionsLocked forgets the history of a removed post or comment; m.mu must be held.
+func (m *MemoryStore) dropRevisionsLocked(kind string, id int) {
+	kept := m.revisions[:0]
+	for _, rev := range m.revisions {
+		if rev.Kind != kind || rev.TargetID != id {
+			kept = append(kept, rev)
+		}
+	}
+	m.revisions = kept
+}
+
+func (m *MemoryStore) ListCategories() ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	categories := append([]Category(nil), m.categories...)
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+func (m *MemoryStore) EditPost(postID int, editorUUID, title, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.posts[postID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.deleted {
+		return ErrDeleted
+	}
+	now := time.Now()
+	m.addRevision(RevisionPost, p.id, p.title, p.content, editorUUID, RevisionEdit, now)
+	p.title, p.content, p.editedAt, p.updatedAt = title, content, &now, now
+	return nil
+}
+
+func (m *MemoryStore) SoftDeletePost(postID int, editorUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.posts[postID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.deleted {
+		return ErrDeleted
+	}
+	now := time.Now()
+	m.addRevision(RevisionPost, p.id, p.title, p.content, editorUUID, RevisionDelete, now)
+	p.title, p.content, p.deleted, p.updatedAt = deletedContent, deletedContent, true, now
+	return nil
+}
+
+// addRevision keeps a version of a post or comment; m.mu must be held.
+func (m *MemoryStore) addRevision(kind string, id int, title, content, editorUUID, reason string, now time.Time) {
+	m.revisions = append(m.revisions, memRevision{
+		Revision: Revision{
+			ID:        m.newID(),
+			Kind:      kind,
+			TargetID:  id,
+			Title:     title,
+			Content:   content,
+			Reason:    reason,
+			CreatedAt: now,
+		},
+		editorUUID: editorUUID,
+	})
+}
+
+// --- CommentStore ---
+
+func (m *MemoryStore) CommentsForPost(postID int, page Page) ([]Comment, PageInfo, error) {
+	cur, err := commentKeyset.decode(page.Cursor)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var flat []Reply
+	for _, r := range m.replies {
+		if c, ok := m.comments[r.commentID]; ok && c.postID == postID {
+			flat = append(flat, Reply{
+				ID:        r.id,
+				Content:   r.content,
+				Author:    m.author(r.authorUUID),
+				Comment:   Comment{ID: r.commentID, Post: Post{ID: postID}},
+				ParentID:  r.parentID,
+				Depth:     r.depth,
+				CreatedAt: r.createdAt,
+				CanReply:  r.depth < MaxReplyDepth,
+			})
+		}
+	}
+	replies := buildReplyTree(flat)
+
+	var comments []Comment
+	for _, c := range m.comments {
+		if c.postID != postID {
+			continue
+		}
+		comment := Comment{
+			ID:        c.id,
+			Content:   c.content,
+			Author:    m.commentAuthor(c),
+			Post:      Post{ID: postID},
+			Replies:   replies[c.id],
+			CreatedAt: c.createdAt,
+			UpdatedAt: c.updatedAt,
+			EditedAt:  c.editedAt,
+			Deleted:   c.deleted,
+		}
+		for key, vote := range m.commentVotes {
+			if key.id != c.id {
+				continue
+			}
+			if vote == VoteLike {
+				comment.LikeCount++
+			} else if vote == VoteDislike {
+				comment.DislikeCount++
+			}
+		}
+		comments = append(comments, comment)
+	}
+	sort.Slice(comments, func(i, j int) bool { return compareKeys(commentKey(comments[i]), commentKey(comments[j])) > 0 })
+	comments, info := pageOf(commentKeyset, seek(comments, page.size(), cur, commentKey), page.size(), cur, commentKey)
+	return comments, info, nil
+}
+
+func (m *MemoryStore) GetComment(commentID int) (*Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.comments[commentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &Comment{
+		ID: c.id, Content: c.content, Author: m.commentAuthor(c), Post: Post{ID: c.postID},
+		CreatedAt: c.createdAt, UpdatedAt: c.updatedAt, EditedAt: c.editedAt, Deleted: c.deleted,
+	}, nil
+}
+
+// commentAuthor is the comment's author as shown next to it; m.mu must be held.
+func (m *MemoryStore) commentAuthor(c *memComment) User {
+	author := m.author(c.authorUUID)
+	author.UUID = c.authorUUID
+	if c.deleted {
+		author.Username = "deleted"
+	}
+	return author
+}
+
+func (m *MemoryStore) AddComment(uuid string, postID int, content string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.posts[postID]; !ok {
+		return 0, errors.New("FOREIGN KEY constraint failed")
+	}
+	now := time.Now()
+	c := &memComment{id: m.newID(), postID: postID, authorUUID: uuid, content: content, createdAt: now, updatedAt: now}
+	m.comments[c.id] = c
+	return c.id, nil
+}
+
+func (m *MemoryStore) EditComment(commentID int, editorUUID, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.comments[commentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if c.deleted {
+		return ErrDeleted
+	}
+	now := time.Now()
+	m.addRevision(RevisionComment, c.id, "", c.content, editorUUID, RevisionEdit, now)
+	c.content, c.editedAt, c.updatedAt = content, &now, now
+	return nil
+}
+
+func (m *MemoryStore) SoftDeleteComment(commentID int, editorUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.comments[commentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if c.deleted {
+		return ErrDeleted
+	}
+	now := time.Now()
+	m.addRevision(RevisionComment, c.id, "", c.content, editorUUID, RevisionDelete, now)
+	c.content, c.deleted, c.updatedAt = deletedContent, true, now
+	return nil
+}
+
+func (m *MemoryStore) AddReply(uuid string, commentID, parentID int, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.comments[commentID]; !ok {
+		return errors.New("comment not found")
+	}
+
+	depth := 1
+	if parentID != 0 {
+		found := false
+		for _, r := range m.replies {
+			if r.id == parentID {
+				if r.commentID != commentID {
+					return ErrReplyElsewhere
+				}
+				depth, found = r.depth+1, true
+				break
+			}
+		}
+		if !found {
+			return ErrReplyNotFound
+		}
+	}
+	if depth > MaxReplyDepth {
+		return ErrReplyTooDeep
+	}
+
+	m.replies = append(m.replies, memReply{
+		id: m.newID(), commentID: commentID, parentID: parentID, depth: depth,
+		authorUUID: uuid, content: content, createdAt: time.Now(),
+	})
+	return nil
+}
+
+// --- InteractionStore ---
+
+func (m *MemoryStore) VotePost(uuid string, postID int, kind VoteKind) (VoteKind, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return toggleVote(m.postVotes, memVoteKey{uuid, postID}, kind)
+}
+
+func (m *MemoryStore) VoteComment(uuid string, commentID int, kind VoteKind) (VoteKind, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return toggleVote(m.commentVotes, memVoteKey{uuid, commentID}, kind)
+}
+
+func (m *MemoryStore) PostVote(uuid string, postID int) (VoteKind, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.postVotes[memVoteKey{uuid, postID}], nil
+}
+
+func (m *MemoryStore) CommentVotes(uuid string, postID int) (map[int]VoteKind, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	votes := make(map[int]VoteKind)
+	for key, vote := range m.commentVotes {
+		if c, ok := m.comments[key.id]; ok && key.uuid == uuid && c.postID == postID {
+			votes[key.id] = vote
+		}
+	}
+	return votes, nil
+}
+
+// toggleVote applies the same toggle rules as DataBase.vote.
+func toggleVote(votes map[memVoteKey]VoteKind, key memVoteKey, kind VoteKind) (VoteKind, error) {
+	if kind != VoteLike && kind != VoteDislike {
+		return VoteNone, errors.New("vote must be a like or a dislike")
+	}
+	if votes[key] == kind {
+		delete(votes, key)
+		return VoteNone, nil
+	}
+	votes[key] = kind
+	return kind, nil
+}
+
+// --- RevisionStore ---
+
+func (m *MemoryStore) PostRevisions(postID int) ([]Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var revisions []Revision
+	for _, rev := range m.revisions {
+		belongs := rev.Kind == RevisionPost && rev.TargetID == postID
+		if c, ok := m.comments[rev.TargetID]; ok && rev.Kind == RevisionComment {
+			belongs = c.postID == postID
+		}
+		if !belongs {
+			continue
+		}
+		r := rev.Revision
+		r.Editor = m.author(rev.editorUUID)
+		if p, ok := m.posts[postID]; ok && rev.Kind == RevisionPost {
+			r.Author = p.authorUUID
+		} else if c, ok := m.comments[rev.TargetID]; ok {
+			r.Author = c.authorUUID
+		}
+		revisions = append(revisions, r)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].ID > revisions[j].ID })
+	return revisions, nil
+}
+
+// --- SessionStore ---
+
+func (m *MemoryStore) CreateSession(uuid string, r *http.Request) (*Session, error) {
+	session, err := newSession(uuid, r)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[uuid]; !ok {
+		return nil, errors.New("FOREIGN KEY constraint failed")
+	}
+	session.ID = m.newID()
+	m.sessions = append(m.sessions, &memSession{Session: *session, tokenHash: hashToken(session.Token)})
+	return session, nil
+}
+
+func (m *MemoryStore) SessionByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.tokenHash == hashToken(token) {
+			session := s.Session
+			session.Token = token
+			return &session, sessionStatus(&session, s.revokedReason)
+		}
+	}
+	return nil, ErrNoSession
+}
+
+func (m *MemoryStore) TouchSession(session *Session) error {
+	now := time.Now()
+	session.ExpiresAt = now.Add(SessionTimeout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.ID == session.ID {
+			s.ExpiresAt = session.ExpiresAt
+		}
+	}
+	if user, ok := m.users[session.UserUUID]; ok {
+		user.Lastseen = now
+		m.users[session.UserUUID] = user
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeSession(token, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.tokenHash == hashToken(token) && s.revokedReason == "" {
+			s.revokedReason = reason
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserSessions(uuid, reason string) (int64, error) {
+	return m.revokeSessions(func(s *memSession) bool { return s.UserUUID == uuid }, reason), nil
+}
+
+func (m *MemoryStore) RevokeOtherSessions(session *Session, reason string) (int64, error) {
+	return m.revokeSessions(func(s *memSession) bool { return s.UserUUID == session.UserUUID && s.ID != session.ID }, reason), nil
+}
+
+// revokeSessions revokes the live sessions matching match and counts them.
+func (m *MemoryStore) revokeSessions(match func(*memSession) bool, reason string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for _, s := range m.sessions {
+		if match(s) && s.revokedReason == "" && s.ExpiresAt.After(now) {
+			s.revokedReason = reason
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemoryStore) RecordSessionEvent(session *Session, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, event)
+	return nil
+}
+
+// --- SubForumStore ---
+
+func (m *MemoryStore) CreateSubForum(name, creatorUUID string) (*SubForum, error) {
+	name, err := normalizeSubForumName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.forums {
+		if f.Name == name {
+			return nil, ErrSubForumExists
+		}
+	}
+	if _, ok := m.users[creatorUUID]; !ok {
+		return nil, errors.New("FOREIGN KEY constraint failed")
+	}
+	f := &memForum{
+		SubForum: SubForum{ID: m.newID(), Name: name, Creator: User{UUID: creatorUUID}},
+		admins:   []string{creatorUUID},
+		posts:    make(map[int]bool),
+	}
+	m.forums[f.ID] = f
+	forum := f.SubForum
+	return &forum, nil
+}
+
+func (m *MemoryStore) SubForumByName(name string) (*SubForum, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.forums {
+		if f.Name != name {
+			continue
+		}
+		forum := f.SubForum
+		forum.Creator = m.author(f.Creator.UUID)
+		forum.Creator.UUID = f.Creator.UUID
+		for _, uuid := range f.admins {
+			admin := m.author(uuid)
+			admin.UUID = uuid
+			forum.Admins = append(forum.Admins, admin)
+		}
+		sort.Slice(forum.Admins, func(i, j int) bool { return forum.Admins[i].Username < forum.Admins[j].Username })
+		return &forum, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) SubForumNames() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for _, f := range m.forums {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemoryStore) SubForumPosts(forumID int) ([]SubForumPost, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.forums[forumID]
+	if !ok {
+		return nil, nil
+	}
+	var posts []SubForumPost
+	for id, pinned := range f.posts {
+		if p, ok := m.posts[id]; ok && !p.deleted {
+			posts = append(posts, SubForumPost{Post: m.post(p), Pinned: pinned})
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i], posts[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	return posts, nil
+}
+
+func (m *MemoryStore) AddSubForumAdmin(forumID int, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var uuid string
+	for id, user := range m.users {
+		if sameFold(user.Username, username) && !user.NotRegistered {
+			uuid = id
+		}
+	}
+	if uuid == "" {
+		return ErrNoSuchUser
+	}
+	f, ok := m.forums[forumID]
+	if !ok {
+		return errors.New("FOREIGN KEY constraint failed")
+	}
+	if !containsString(f.admins, uuid) {
+		f.admins = append(f.admins, uuid)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetPinned(forumID, postID int, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.forums[forumID]
+	if !ok {
+		return errors.New("post is not in this sub-forum")
+	}
+	if _, ok := f.posts[postID]; !ok {
+		return errors.New("post is not in this sub-forum")
+	}
+	f.posts[postID] = pinned
+	return nil
+}
+
+func (m *MemoryStore) InSubForum(forumID, postID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.forums[forumID]
+	if !ok {
+		return false, nil
+	}
+	_, ok = f.posts[postID]
+	return ok, nil
+}
+
+func (m *MemoryStore) ModeratesPost(uuid string, postID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.forums {
+		if _, ok := f.posts[postID]; ok && containsString(f.admins, uuid) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// --- SecretStore ---
+
+func (m *MemoryStore) Secret(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.secrets[name]; ok {
+		return key, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	m.secrets[name] = key
+	return key, nil
+}
+
+// --- PasswordResetStore ---
+
+func (m *MemoryStore) CreatePasswordReset(user *User, r *http.Request) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, reset := range m.resets {
+		if reset.userUUID == user.UUID {
+			reset.used = true
+		}
+	}
+	m.resets[hashToken(token)] = &memReset{userUUID: user.UUID, expiresAt: time.Now().Add(PasswordResetTTL)}
+	return token, nil
+}
+
+func (m *MemoryStore) PasswordResetUser(token string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset, err := m.passwordReset(token)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := m.users[reset.userUUID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return &user, nil
+}
+
+func (m *MemoryStore) UsePasswordReset(token, hash string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset, err := m.passwordReset(token)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := m.users[reset.userUUID]
+	if !ok || user.NotRegistered {
+		return nil, ErrInvalidToken
+	}
+	reset.used = true
+	user.Password, user.EmailVerified = hash, true
+	m.users[user.UUID] = user
+	return &user, nil
+}
+
+// passwordReset finds an unused, unexpired reset token; m.mu must be held.
+func (m *MemoryStore) passwordReset(token string) (*memReset, error) {
+	reset, ok := m.resets[hashToken(token)]
+	if !ok || reset.used {
+		return nil, ErrInvalidToken
+	}
+	if !reset.expiresAt.After(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+	return reset, nil
+}
+
+// --- ThrottleStore ---
+
+func (m *MemoryStore) ThrottleWait(p ThrottlePolicy, keys ...string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := p.now()
+	var wait time.Duration
+	for _, key := range keys {
+		wait = max(wait, p.wait(m.throttle[key], now))
+	}
+	return wait, nil
+}
+
+func (m *MemoryStore) RecordFailure(p ThrottlePolicy, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := p.now()
+	for _, key := range keys {
+		m.throttle[key] = p.fail(m.throttle[key], now)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ClearFailures(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.throttle, key)
+	}
+	return nil
+}
+
+// --- AccountStore ---
+
+func (m *MemoryStore) ExportUserData(uuid string) (*UserExport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[uuid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	export := &UserExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			UUID:          user.UUID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			LastSeen:      user.Lastseen,
+		},
+		Posts:        []ExportPost{},
+		Comments:     []ExportComment{},
+		Replies:      []ExportReply{},
+		PostVotes:    []ExportVote{},
+		CommentVotes: []ExportVote{},
+		SubForums:    []ExportSubForum{},
+		Sessions:     []ExportSession{},
+		Revisions:    []ExportRevision{},
+	}
+
+	for _, p := range m.posts {
+		if p.authorUUID != uuid {
+			continue
+		}
+		post := ExportPost{
+			ID: p.id, Title: p.title, Content: p.content, Categories: append([]string{}, p.categories...),
+			CreatedAt: sessionTime(p.createdAt), UpdatedAt: sessionTime(p.updatedAt),
+		}
+		for _, f := range m.forums {
+			if _, ok := f.posts[p.id]; ok {
+				post.SubForum = f.Name
+			}
+		}
+		export.Posts = append(export.Posts, post)
+	}
+	sort.Slice(export.Posts, func(i, j int) bool { return export.Posts[i].ID < export.Posts[j].ID })
+
+	for _, c := range m.comments {
+		if c.authorUUID == uuid {
+			export.Comments = append(export.Comments, ExportComment{
+				ID: c.id, PostID: c.postID, Content: c.content,
+				CreatedAt: sessionTime(c.createdAt), UpdatedAt: sessionTime(c.updatedAt),
+			})
+		}
+	}
+	sort.Slice(export.Comments, func(i, j int) bool { return export.Comments[i].ID < export.Comments[j].ID })
+
+	for _, r := range m.replies {
+		if r.authorUUID == uuid {
+			export.Replies = append(export.Replies, ExportReply{
+				ID: r.id, CommentID: r.commentID, ParentID: r.parentID, Content: r.content, CreatedAt: sessionTime(r.createdAt),
+			})
+		}
+	}
+
+	votes := func(all map[memVoteKey]VoteKind) []ExportVote {
+		list := []ExportVote{}
+		for key, vote := range all {
+			if key.uuid == uuid {
+				list = append(list, ExportVote{ID: key.id, Vote: vote.String()})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list
+	}
+	export.PostVotes = votes(m.postVotes)
+	export.CommentVotes = votes(m.commentVotes)
+
+	for _, f := range m.forums {
+		creator, admin := f.Creator.UUID == uuid, containsString(f.admins, uuid)
+		if creator || admin {
+			export.SubForums = append(export.SubForums, ExportSubForum{ID: f.ID, Name: f.Name, Creator: creator, Admin: admin})
+		}
+	}
+	sort.Slice(export.SubForums, func(i, j int) bool { return export.SubForums[i].ID < export.SubForums[j].ID })
+
+	for _, s := range m.sessions {
+		if s.UserUUID == uuid {
+			export.Sessions = append(export.Sessions, ExportSession{
+				CreatedAt: sessionTime(s.CreatedAt), ExpiresAt: sessionTime(s.ExpiresAt),
+				IP: s.IP, UserAgent: s.UserAgent, RevokedReason: s.revokedReason,
+			})
+		}
+	}
+
+	for _, rev := range m.revisions {
+		author := ""
+		if p, ok := m.posts[rev.TargetID]; ok && rev.Kind == RevisionPost {
+			author = p.authorUUID
+		}
+		if c, ok := m.comments[rev.TargetID]; ok && rev.Kind == RevisionComment {
+			author = c.authorUUID
+		}
+		if author == uuid {
+			export.Revisions = append(export.Revisions, ExportRevision{
+				Kind: rev.Kind, TargetID: rev.TargetID, Title: rev.Title, Content: rev.Content,
+				Reason: rev.Reason, CreatedAt: sessionTime(rev.CreatedAt),
+			})
+		}
+	}
+	return export, nil
+}
+
+func (m *MemoryStore) DeleteAccount(uuid, mode string) error {
+	if uuid == DeletedUserUUID {
+		return errors.New("the tombstone account can't be deleted")
+	}
+	if mode != DeleteAnonymize && mode != DeleteErase {
+		return fmt.Errorf("unknown deletion mode %q", mode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[uuid]
+	if !ok || user.NotRegistered {
+		return sql.ErrNoRows
+	}
+	for _, key := range userThrottleKeys(&user) {
+		delete(m.throttle, key)
+	}
+
+	if mode == DeleteErase {
+		m.eraseContentLocked(uuid)
+	}
+
+	for _, p := range m.posts {
+		if p.authorUUID == uuid {
+			p.authorUUID = DeletedUserUUID
+		}
+	}
+	for _, c := range m.comments {
+		if c.authorUUID == uuid {
+			c.authorUUID = DeletedUserUUID
+		}
+	}
+	for i := range m.replies {
+		if m.replies[i].authorUUID == uuid {
+			m.replies[i].authorUUID = DeletedUserUUID
+		}
+	}
+	for _, f := range m.forums {
+		if f.Creator.UUID == uuid {
+			f.Creator.UUID = DeletedUserUUID
+		}
+		admins := f.admins[:0]
+		for _, admin := range f.admins {
+			if admin != uuid {
+				admins = append(admins, admin)
+			}
+		}
+		f.admins = admins
+	}
+	for i := range m.revisions {
+		if m.revisions[i].editorUUID == uuid {
+			m.revisions[i].editorUUID = DeletedUserUUID
+		}
+	}
+	for _, votes := range []map[memVoteKey]VoteKind{m.postVotes, m.commentVotes} {
+		for key := range votes {
+			if key.uuid == uuid {
+				delete(votes, key)
+			}
+		}
+	}
+	sessions := m.sessions[:0]
+	for _, s := range m.sessions {
+		if s.UserUUID != uuid {
+			sessions = append(sessions, s)
+		}
+	}
+	m.sessions = sessions
+	for token, reset := range m.resets {
+		if reset.userUUID == uuid {
+			delete(m.resets, token)
+		}
+	}
+	delete(m.users, uuid)
+	return nil
+}
+
+// eraseContentLocked works like eraseContentTx; m.mu must be held.
+func (m *MemoryStore) eraseContentLocked(uuid string) {
+	for id, p := range m.posts {
+		if p.authorUUID == uuid {
+			m.deletePostLocked(id)
+		}
+	}
+	answeredByOthers := make(map[int]bool)
+	for _, r := range m.replies {
+		if r.authorUUID != uuid {
+			answeredByOthers[r.commentID] = true
+		}
+	}
+	now := time.Now()
+	for id, c := range m.comments {
+		if c.authorUUID != uuid {
+			continue
+		}
+		if !answeredByOthers[id] {
+			m.deleteCommentLocked(id)
+			continue
+		}
+		m.dropRevisionsLocked(RevisionComment, id)
+		c.content, c.deleted, c.updatedAt = deletedContent, true, now
+	}
+
+	answered := make(map[int]bool)
+	for _, r := range m.replies {
+		answered[r.parentID] = true
+	}
+	kept := m.replies[:0]
+	for _, r := range m.replies {
+		if r.authorUUID == uuid {
+			if !answered[r.id] {
+				continue
+			}
+			r.content = deletedContent
+		}
+		kept = append(kept, r)
+	}
+	m.replies = kept
+}
+
+// --- SearchStore ---
+
+// Search matches every word of q.Text as a case-insensitive prefix of a
+// word in the title or content. Title matches rank first, then newer ones.
+func (m *MemoryStore) Search(q SearchQuery) ([]SearchResult, error) {
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type hit struct {
+		SearchResult
+		inTitle bool
+		id      int
+	}
+	var hits []hit
+	add := func(p *memPost, commentID int, authorUUID, title, content string, createdAt time.Time) {
+		inTitle := matchesTerms(title, terms)
+		if !inTitle && !matchesTerms(title+" "+content, terms) {
+			return
+		}
+		if q.Category != "" && !containsString(p.categories, q.Category) {
+			return
+		}
+		author := m.users[authorUUID].Username
+		if q.Author != "" && !sameFold(author, q.Author) {
+			return
+		}
+		if !q.From.IsZero() && createdAt.Before(q.From) {
+			return
+		}
+		if !q.To.IsZero() && !createdAt.Before(q.To.AddDate(0, 0, 1)) {
+			return
+		}
+		id := p.id * 2
+		if commentID != 0 {
+			id = commentID*2 + 1
+		}
+		hits = append(hits, hit{
+			SearchResult: SearchResult{
+				PostID: p.id, CommentID: commentID, Title: p.title,
+				Snippet: highlight(markTerms(content, terms)), Author: author, CreatedAt: createdAt,
+			},
+			inTitle: inTitle,
+			id:      id,
+		})
+	}
+	for _, p := range m.posts {
+		if !p.deleted {
+			add(p, 0, p.authorUUID, p.title, p.content, p.createdAt)
+		}
+	}
+	for _, c := range m.comments {
+		if p := m.posts[c.postID]; p != nil && !p.deleted && !c.deleted {
+			add(p, c.id, c.authorUUID, "", c.content, c.createdAt)
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].inTitle != hits[j].inTitle {
+			return hits[i].inTitle
+		}
+		return hits[i].id > hits[j].id
+	})
+	var results []SearchResult
+	for _, h := range hits {
+		if len(results) == SearchLimit {
+			break
+		}
+		results = append(results, h.SearchResult)
+	}
+	return results, nil
+}
+
+// matchesTerms reports whether every term starts some word of text, ignoring case.
+func matchesTerms(text string, terms []string) bool {
+	words := searchTerms(strings.ToLower(text))
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// markTerms wraps the words of text that start with a term in the markers highlight looks for.
+func markTerms(text string, terms []string) string {
+	var b strings.Builder
+	word := func(w string) {
+		for _, term := range terms {
+			if strings.HasPrefix(strings.ToLower(w), strings.ToLower(term)) {
+				b.WriteString(markStart + w + markEnd)
+				return
+			}
+		}
+		b.WriteString(w)
+	}
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			word(text[start:i])
+			start = -1
+		}
+		if !inWord {
+			b.WriteRune(r)
+		}
+	}
+	if start >= 0 {
+		word(text[start:])
+	}
+	return b.String()
+}
+
+// sameFold matches the SQLite indexes: case-insensitive, trimmed, empty never matches.
+func sameFold(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	return a != "" && strings.EqualFold(a, b)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
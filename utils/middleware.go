@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const authKey contextKey = iota
+
+// authState is what Authenticate learned about the request's session.
+type authState struct {
+	user    *User
+	session *Session
+	err     error
+}
+
+// Authenticate resolves the session cookie once per request, refreshes the
+// session and stores the user in the request context for CurrentUser.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Static files don't need a user, and shouldn't keep sessions alive
+		if strings.HasPrefix(r.URL.Path, "/static/") || GetSessionToken(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		state := &authState{}
+		state.session, state.err = CheckSession(w, r)
+		if state.err == nil {
+			state.user, state.err = store.UserByUUID(state.session.UserUUID)
+			if errors.Is(state.err, sql.ErrNoRows) {
+				// The account is gone, so the session is useless
+				ClearUserCookie(w)
+				state.err = ErrNoSession
+			}
+			if state.err != nil {
+				state.user, state.session = nil, nil
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey, state)))
+	})
+}
+
+// auth returns the request's authState; requests without a session get an empty one.
+func auth(r *http.Request) *authState {
+	if state, ok := r.Context().Value(authKey).(*authState); ok {
+		return state
+	}
+	return &authState{err: ErrNoSession}
+}
+
+// CurrentUser returns the logged-in user (registered or guest), or nil.
+func CurrentUser(r *http.Request) *User {
+	return auth(r).user
+}
+
+// CurrentSession returns the session behind the request, or nil.
+func CurrentSession(r *http.Request) *Session {
+	return auth(r).session
+}
+
+// currentUUID returns the logged-in user's UUID, or "" for anonymous requests.
+func currentUUID(r *http.Request) string {
+	if user := CurrentUser(r); user != nil {
+		return user.UUID
+	}
+	return ""
+}
+
+// sessionError explains why CurrentUser is nil, e.g. ErrNoSession or ErrSessionExpired.
+func sessionError(r *http.Request) error {
+	return auth(r).err
+}
+
+// RequireGuestOrUser only lets requests with a live session, guest or registered, through.
+func RequireGuestOrUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if CurrentUser(r) != nil {
+			next(w, r)
+			return
+		}
+
+		switch err := sessionError(r); {
+		case errors.Is(err, ErrNoSession), errors.Is(err, ErrSessionReplaced):
+			// /login explains a replaced session
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		case errors.Is(err, ErrSessionExpired), errors.Is(err, ErrSessionRevoked):
+			RenderError(w, "Session expired. Please log in again.", http.StatusUnauthorized)
+		default:
+			log.Println("Error checking session:", err)
+			RenderError(w, "Failed to check session", http.StatusInternalServerError)
+		}
+	}
+}
+
+// RequireRegistered is RequireGuestOrUser that also turns away guests and
+// members who haven't verified their email yet.
+func RequireRegistered(next http.HandlerFunc) http.HandlerFunc {
+	return RequireGuestOrUser(func(w http.ResponseWriter, r *http.Request) {
+		user := CurrentUser(r)
+		if user.NotRegistered {
+			RenderError(w, "Guests can only browse. Register to join in.", http.StatusForbidden)
+			return
+		}
+		if !user.EmailVerified {
+			RenderError(w, "Please verify your email address first. Use the link we sent you, or request a new one from the home page.", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
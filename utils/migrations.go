@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationsDir holds the numbered schema migrations, e.g.
+// 0003_replies.up.sql and 0003_replies.down.sql.
+const MigrationsDir = "sql/migrations"
+
+// Migration is one numbered schema change and how to undo it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations reads every migration in dir, sorted by version.
+// Each version needs both an .up.sql and a .down.sql file.
+func LoadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		file := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(file, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must start with a positive version number", file)
+		}
+		name := strings.TrimSuffix(rest, "."+direction+".sql")
+
+		sqlBytes, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(sqlBytes)
+		} else {
+			m.Down = string(sqlBytes)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationChecks run inside a migration's transaction before its script.
+// They let a migration explain why it can't apply instead of failing on a
+// bare constraint error.
+var migrationChecks = map[int]func(tx *sql.Tx) error{
+	9:  checkUserCollisions,
+	12: checkDeletedUsername,
+	16: func(tx *sql.Tx) error { return checkFTS5(tx) },
+}
+
+// ensureMigrationsTable creates the bookkeeping table on first use.
+func (db *DataBase) ensureMigrationsTable() error {
+	_, err := db.Conn.Exec(`
+        create table if not exists schema_migrations (
+            version integer primary key,
+            name text not null,
+            applied_at text not null
+        )
+    `)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for a fresh database.
+func (db *DataBase) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.Conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// appliedVersions returns the set of migration versions already applied.
+func (db *DataBase) appliedVersions() (map[int]bool, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := db.Conn.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		done[v] = true
+	}
+	return done, rows.Err()
+}
+
+// Migrate applies every pending migration from dir, each in its own transaction,
+// and returns the ones it applied.
+func (db *DataBase) Migrate(dir string) ([]Migration, error) {
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	done, err := db.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if done[m.Version] {
+			continue
+		}
+		if err := db.runMigration(m.Up, migrationChecks[m.Version], func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().Format(time.RFC3339))
+			return err
+		}); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Rollback undoes the last steps applied migrations, newest first,
+// and returns the ones it rolled back.
+func (db *DataBase) Rollback(dir string, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]Migration)
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := db.Conn.Query("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT ?", steps)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+
+	var rolledBack []Migration
+	for _, v := range versions {
+		m, ok := byVersion[v]
+		if !ok {
+			return rolledBack, fmt.Errorf("migration %d is applied but its files are missing", v)
+		}
+		if err := db.runMigration(m.Down, nil, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		}); err != nil {
+			return rolledBack, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		rolledBack = append(rolledBack, m)
+	}
+	return rolledBack, nil
+}
+
+// runMigration executes the optional check, script and the bookkeeping step in one transaction.
+func (db *DataBase) runMigration(script string, check, record func(*sql.Tx) error) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if check != nil {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+
+	// The SQLite driver runs multi-statement scripts in a single Exec
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schemaObjects lists the tables, indexes and triggers of d other than SQLite's own.
+func schemaObjects(t *testing.T, d *DataBase) []string {
+	t.Helper()
+	rows, err := d.Conn.Query(`SELECT type || ' ' || name FROM sqlite_master
+        WHERE name NOT LIKE 'sqlite_%' ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func schemaVersion(t *testing.T, d *DataBase) int {
+	t.Helper()
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateAllTheWay(t *testing.T) {
+	d := openTestDB(t)
+	migrations, err := LoadMigrations(MigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+	if v := schemaVersion(t, d); v != latest {
+		t.Fatalf("fresh database at version %d, want %d", v, latest)
+	}
+	migrated := schemaObjects(t, d)
+
+	rolledBack, err := d.Rollback(MigrationsDir, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != len(migrations) {
+		t.Errorf("rolled back %d migrations, want %d", len(rolledBack), len(migrations))
+	}
+	if v := schemaVersion(t, d); v != 0 {
+		t.Errorf("version %d after rolling everything back, want 0", v)
+	}
+	if left := schemaObjects(t, d); strings.Join(left, ", ") != "table schema_migrations" {
+		t.Errorf("left after rolling everything back: %v", left)
+	}
+
+	applied, err := d.Migrate(MigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) || schemaVersion(t, d) != latest {
+		t.Fatalf("migrating again applied %d, at version %d; want %d, %d", len(applied), schemaVersion(t, d), len(migrations), latest)
+	}
+	if again := schemaObjects(t, d); strings.Join(again, ", ") != strings.Join(migrated, ", ") {
+		t.Errorf("schema after migrating again:\n%v\nwant\n%v", again, migrated)
+	}
+
+	// The schema still works
+	alice := addUser(t, d, "alice")
+	if _, err := d.CreatePost(alice.UUID, "Title", "text", []string{"go"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	d := openTestDB(t)
+	before := schemaVersion(t, d)
+	objects := schemaObjects(t, d)
+
+	// The real migrations plus one that fails half-way through
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(MigrationsDir)); err != nil {
+		t.Fatal(err)
+	}
+	up := "create table half_done (id integer primary key);\ninsert into no_such_table values (1);\n"
+	if err := os.WriteFile(filepath.Join(dir, "0999_broken.up.sql"), []byte(up), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0999_broken.down.sql"), []byte("drop table if exists half_done;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := d.Migrate(dir)
+	if err == nil || !strings.Contains(err.Error(), "0999_broken") {
+		t.Fatalf("Migrate = %v, want the broken migration to fail", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %v, want nothing", applied)
+	}
+	if v := schemaVersion(t, d); v != before {
+		t.Errorf("version %d after the failure, want %d", v, before)
+	}
+	if after := schemaObjects(t, d); strings.Join(after, ", ") != strings.Join(objects, ", ") {
+		t.Errorf("schema changed by the failed migration:\n%v\nwant\n%v", after, objects)
+	}
+
+	// Nor does a down script that fails undo anything
+	if err := os.WriteFile(filepath.Join(dir, "0999_broken.up.sql"), []byte("create table half_done (id integer primary key);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0999_broken.down.sql"), []byte("drop table half_done;\ndrop table no_such_table;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Migrate(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Rollback(dir, 1); err == nil {
+		t.Fatal("Rollback succeeded, want the broken down script to fail")
+	}
+	if v := schemaVersion(t, d); v != 999 {
+		t.Errorf("version %d after the failed rollback, want 999", v)
+	}
+	var n int
+	if err := d.Conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("half_done tables after the failed rollback: %d, %v; want it kept", n, err)
+	}
+}
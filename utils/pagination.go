@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PageSize is how many posts or comments a page shows unless ?limit= asks
+// for another size; MaxPageSize caps ?limit=.
+var (
+	PageSize    = 20
+	MaxPageSize = 100
+)
+
+// ErrInvalidCursor is returned for a cursor that is malformed or belongs to
+// another listing or sort order.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Page asks for one page of a listing. Cursor comes from a previous
+// PageInfo; empty means the first page.
+type Page struct {
+	Size   int
+	Cursor string
+}
+
+// PageInfo holds the cursors of the neighbouring pages; each is empty when
+// there is no such page.
+type PageInfo struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// PageFromRequest reads ?cursor= and ?limit=. An invalid limit falls back to
+// PageSize and a large one is capped at MaxPageSize.
+func PageFromRequest(r *http.Request) Page {
+	page := Page{Size: PageSize, Cursor: r.URL.Query().Get("cursor")}
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		page.Size = min(n, MaxPageSize)
+	}
+	return page
+}
+
+// size is the page size to use, defaulting to PageSize.
+func (p Page) size() int {
+	if p.Size <= 0 {
+		return PageSize
+	}
+	return min(p.Size, MaxPageSize)
+}
+
+// pageLinks links the current page with ?cursor= set to each neighbour,
+// keeping its other query parameters. A link is empty if there is no page.
+func pageLinks(r *http.Request, info PageInfo) (prev, next string) {
+	link := func(cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		return r.URL.Path + "?" + query.Encode()
+	}
+	return link(info.Prev), link(info.Next)
+}
+
+// cursor is a position in a listing: the sort key of an item followed by
+// its ID. Before asks for the page ending just before that item rather than
+// the one starting just after it.
+type cursor struct {
+	Listing string        `json:"l"`
+	Key     []interface{} `json:"k"`
+	Before  bool          `json:"b,omitempty"`
+}
+
+// encode turns the cursor into the opaque string used in URLs and JSON.
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// keyset is a listing order that can be paginated on its keys: items are
+// sorted by each column and then by id, all descending.
+type keyset struct {
+	name    string   // stored in cursors so they only work for this listing
+	columns []string // SQL expressions, most significant first
+	id      string
+}
+
+// decode parses a cursor for this keyset; nil if s is empty. Numbers come
+// back as int64 so they compare like the integer columns they came from.
+func (k keyset) decode(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var c cursor
+	if err := dec.Decode(&c); err != nil || c.Listing != k.name || len(c.Key) != len(k.columns)+1 {
+		return nil, ErrInvalidCursor
+	}
+	for i, value := range c.Key {
+		switch v := value.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			c.Key[i] = n
+		case string:
+			if i == len(c.Key)-1 {
+				return nil, ErrInvalidCursor // the ID
+			}
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
+
+// where returns the condition selecting the items after c, or before it
+// when c.Before is set, with its arguments.
+func (k keyset) where(c *cursor) (string, []interface{}) {
+	op := "<"
+	if c.Before {
+		op = ">"
+	}
+	columns := append(append([]string{}, k.columns...), k.id)
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op, marks), c.Key
+}
+
+// orderBy returns the ORDER BY clause, reversed when reading backwards
+// from a Before cursor.
+func (k keyset) orderBy(c *cursor) string {
+	dir := " DESC"
+	if c != nil && c.Before {
+		dir = " ASC"
+	}
+	terms := make([]string, 0, len(k.columns)+1)
+	for _, col := range append(append([]string{}, k.columns...), k.id) {
+		terms = append(terms, col+dir)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// pageOf trims items, read in query order with one extra row to tell if
+// more follow, to a page and works out the cursors around it. key returns
+// an item's sort key followed by its ID.
+func pageOf[T any](k keyset, items []T, size int, c *cursor, key func(T) []interface{}) ([]T, PageInfo) {
+	more := len(items) > size
+	if more {
+		items = items[:size]
+	}
+	backwards := c != nil && c.Before
+	if backwards {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	var info PageInfo
+	if len(items) == 0 {
+		return items, info
+	}
+	if (backwards && more) || (!backwards && c != nil) {
+		info.Prev = cursor{Listing: k.name, Key: key(items[0]), Before: true}.encode()
+	}
+	if backwards || more {
+		info.Next = cursor{Listing: k.name, Key: key(items[len(items)-1])}.encode()
+	}
+	return items, info
+}
+
+// compareKeys orders two sort keys made of strings and int64s like SQLite
+// compares row values, where integers sort before text.
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		x, xInt := a[i].(int64)
+		y, yInt := b[i].(int64)
+		switch {
+		case xInt && yInt:
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case xInt:
+			return -1
+		case yInt:
+			return 1
+		default:
+			xs, _ := a[i].(string)
+			ys, _ := b[i].(string)
+			if c := strings.Compare(xs, ys); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// seek emulates a keyset query over items already sorted descending: it
+// keeps the size+1 items after c (or before it, nearest first) in the
+// order the database would return them.
+func seek[T any](items []T, size int, c *cursor, key func(T) []interface{}) []T {
+	var out []T
+	if c == nil {
+		out = items
+	} else if c.Before {
+		for i := len(items) - 1; i >= 0; i-- {
+			if compareKeys(key(items[i]), c.Key) > 0 {
+				out = append(out, items[i])
+			}
+		}
+	} else {
+		for _, item := range items {
+			if compareKeys(key(item), c.Key) < 0 {
+				out = append(out, item)
+			}
+		}
+	}
+	if len(out) > size+1 {
+		out = out[:size+1]
+	}
+	return out
+}
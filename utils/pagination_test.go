@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	k := keyset{name: "things", columns: []string{"a", "b"}, id: "id"}
+	tests := []struct {
+		name string
+		key  []interface{}
+	}{
+		{"numbers", []interface{}{int64(3), int64(-2), int64(7)}},
+		{"text", []interface{}{"2025-03-01T12:00:00Z", "x", int64(1)}},
+		{"large ID", []interface{}{int64(0), int64(0), int64(1) << 53}},
+		{"quotes and Unicode", []interface{}{`a"b`, "ünï", int64(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, before := range []bool{false, true} {
+				c, err := k.decode(cursor{Listing: k.name, Key: tt.key, Before: before}.encode())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.Before != before || compareKeys(c.Key, tt.key) != 0 || fmt.Sprint(c.Key) != fmt.Sprint(tt.key) {
+					t.Errorf("decoded %+v, want key %v before %v", c, tt.key, before)
+				}
+			}
+		})
+	}
+
+	if c, err := k.decode(""); c != nil || err != nil {
+		t.Errorf("decode(\"\") = %+v, %v; want the first page", c, err)
+	}
+}
+
+func TestCursorInvalid(t *testing.T) {
+	k := keyset{name: "things", columns: []string{"a"}, id: "id"}
+	valid := cursor{Listing: k.name, Key: []interface{}{int64(5), int64(9)}}.encode()
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	tests := []struct {
+		name, cursor string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"l":"things","k":[5,19]}`))},
+		{"not JSON", raw("hello")},
+		{"truncated", valid[:len(valid)-3]},
+		{"flipped byte", valid[:4] + string(valid[4]^1) + valid[5:]},
+		{"another listing", cursor{Listing: "others", Key: []interface{}{int64(5), int64(9)}}.encode()},
+		{"no listing", raw(`{"k":[5,9]}`)},
+		{"key too short", raw(`{"l":"things","k":[9]}`)},
+		{"key too long", raw(`{"l":"things","k":[5,5,9]}`)},
+		{"text ID", raw(`{"l":"things","k":[5,"9"]}`)},
+		{"fractional number", raw(`{"l":"things","k":[5.5,9]}`)},
+		{"huge number", raw(`{"l":"things","k":[5,99999999999999999999]}`)},
+		{"boolean", raw(`{"l":"things","k":[true,9]}`)},
+		{"null", raw(`{"l":"things","k":[null,9]}`)},
+		{"nested", raw(`{"l":"things","k":[[5],9]}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := k.decode(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decode(%q) = %+v, %v; want ErrInvalidCursor", tt.cursor, c, err)
+			}
+		})
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []interface{}
+		want int
+	}{
+		{"equal", []interface{}{int64(1), int64(2)}, []interface{}{int64(1), int64(2)}, 0},
+		{"smaller number", []interface{}{int64(1), int64(9)}, []interface{}{int64(2), int64(1)}, -1},
+		{"larger number", []interface{}{int64(2), int64(1)}, []interface{}{int64(1), int64(9)}, 1},
+		{"negative", []interface{}{int64(-3), int64(1)}, []interface{}{int64(0), int64(1)}, -1},
+		{"tie broken by ID", []interface{}{int64(4), int64(1)}, []interface{}{int64(4), int64(2)}, -1},
+		{"tie on text broken by ID", []interface{}{"2025-03-01T12:00:00Z", int64(8)}, []interface{}{"2025-03-01T12:00:00Z", int64(3)}, 1},
+		{"text", []interface{}{"2025-03-01T12:00:00Z", int64(1)}, []interface{}{"2025-03-02T00:00:00Z", int64(1)}, -1},
+		{"integers before text", []interface{}{int64(99), int64(1)}, []interface{}{"0", int64(1)}, -1},
+		{"text after integers", []interface{}{"0", int64(1)}, []interface{}{int64(99), int64(1)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareKeys(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareKeys(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// walkPages reads a listing a page at a time, following Next from the first
+// page to the last and then Prev back to the first. It checks both walks
+// see the same pages and that only the ends lack a cursor, and returns the
+// items in order.
+func walkPages(t *testing.T, list func(cursor string) ([]int, PageInfo, error)) []int {
+	t.Helper()
+	var pages [][]int
+	var all []int
+	var prev string
+	next := ""
+	for {
+		items, info, err := list(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) == 0 && info.Prev != "" {
+			t.Errorf("first page %v has a previous page", items)
+		}
+		if len(pages) > 0 && info.Prev == "" {
+			t.Errorf("page %d %v has no previous page", len(pages)+1, items)
+		}
+		pages = append(pages, items)
+		all = append(all, items...)
+		if len(pages) > 100 {
+			t.Fatal("more than 100 pages; the cursors go round in circles")
+		}
+		if info.Next == "" {
+			prev = info.Prev
+			break
+		}
+		next = info.Next
+	}
+
+	for i := len(pages) - 2; i >= 0; i-- {
+		if prev == "" {
+			t.Fatalf("page %d has no previous page on the way back", i+2)
+		}
+		items, info, err := list(prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(items) != fmt.Sprint(pages[i]) {
+			t.Errorf("page %d going back = %v, want %v", i+1, items, pages[i])
+		}
+		if info.Next == "" {
+			t.Errorf("page %d going back has no next page", i+1)
+		}
+		if i == 0 && info.Prev != "" {
+			t.Errorf("first page going back has a previous page")
+		}
+		prev = info.Prev
+	}
+	return all
+}
+
+func TestSeekPages(t *testing.T) {
+	k := keyset{name: "things", columns: []string{"score"}, id: "id"}
+	type thing struct{ score, id int }
+	key := func(x thing) []interface{} { return []interface{}{int64(x.score), int64(x.id)} }
+	// Sorted the way the database would: score, then ID, descending
+	things := []thing{{5, 4}, {5, 2}, {3, 7}, {3, 6}, {3, 1}, {1, 5}, {0, 3}}
+
+	for size := 1; size <= len(things)+1; size++ {
+		t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+			got := walkPages(t, func(s string) ([]int, PageInfo, error) {
+				c, err := k.decode(s)
+				if err != nil {
+					return nil, PageInfo{}, err
+				}
+				page, info := pageOf(k, seek(things, size, c, key), size, c, key)
+				ids := make([]int, len(page))
+				for i, x := range page {
+					ids[i] = x.id
+				}
+				return ids, info, nil
+			})
+			if want := "[4 2 7 6 1 5 3]"; fmt.Sprint(got) != want {
+				t.Errorf("pages = %v, want %s", got, want)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		page, info := pageOf(k, seek([]thing(nil), 2, nil, key), 2, nil, key)
+		if len(page) != 0 || info != (PageInfo{}) {
+			t.Errorf("empty listing = %v, %+v; want no items or cursors", page, info)
+		}
+	})
+
+	t.Run("past the end", func(t *testing.T) {
+		c := &cursor{Listing: k.name, Key: []interface{}{int64(0), int64(3)}}
+		page, info := pageOf(k, seek(things, 2, c, key), 2, c, key)
+		if len(page) != 0 || info != (PageInfo{}) {
+			t.Errorf("after the last item = %v, %+v; want no items or cursors", page, info)
+		}
+	})
+
+	t.Run("before the start", func(t *testing.T) {
+		c := &cursor{Listing: k.name, Key: []interface{}{int64(5), int64(4)}, Before: true}
+		page, info := pageOf(k, seek(things, 2, c, key), 2, c, key)
+		if len(page) != 0 || info != (PageInfo{}) {
+			t.Errorf("before the first item = %v, %+v; want no items or cursors", page, info)
+		}
+	})
+}
+
+// setPostCreated backdates a post so tests can line up ties.
+func setPostCreated(t *testing.T, s Store, postID int, at time.Time) {
+	t.Helper()
+	switch s := s.(type) {
+	case *MemoryStore:
+		s.mu.Lock()
+		s.posts[postID].createdAt = at
+		s.mu.Unlock()
+	case *DataBase:
+		if _, err := s.Conn.Exec("UPDATE posts SET created_at = ? WHERE id = ?", sessionTime(at), postID); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("can't backdate posts in a %T", s)
+	}
+}
+
+func TestListPostsPages(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		voters := []*User{addUser(t, s, "bob"), addUser(t, s, "carol")}
+
+		day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+		// Each post's age in days and votes, one per voter; the comments
+		// are written now, which makes their posts the most active
+		seed := []struct {
+			age     int
+			votes   []VoteKind
+			comment bool
+		}{
+			{0, []VoteKind{VoteLike, VoteLike}, false},
+			{2, []VoteKind{VoteLike, VoteDislike}, false},
+			{0, []VoteKind{VoteLike, VoteLike}, false},
+			{1, nil, false},
+			{2, []VoteKind{VoteDislike, VoteLike}, true},
+			{1, []VoteKind{VoteDislike}, false},
+			{2, nil, false},
+		}
+		ids := make([]int, len(seed))
+		for i, p := range seed {
+			id, err := s.CreatePost(alice.UUID, fmt.Sprintf("Post %d", i+1), "text", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids[i] = id
+			setPostCreated(t, s, id, day.AddDate(0, 0, -p.age))
+			for j, kind := range p.votes {
+				if _, err := s.VotePost(voters[j].UUID, id, kind); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if p.comment {
+				if _, err := s.AddComment(voters[0].UUID, id, "busy"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		// The orders below number the posts from 1 as seed does
+		tests := []struct {
+			sort PostSort
+			want []int
+		}{
+			// new: created today 3, 1; yesterday 6, 4; two days ago 7, 5, 2
+			{SortNew, []int{3, 1, 6, 4, 7, 5, 2}},
+			// top: score 2 (3, 1); score 0 with a like (5, 2), without (7, 4); -1 (6)
+			{SortTop, []int{3, 1, 5, 2, 7, 4, 6}},
+			// controversial: one of each (5, 2); two likes (3, 1); one dislike (6); none (7, 4)
+			{SortControversial, []int{5, 2, 3, 1, 6, 7, 4}},
+			// active: the comment on 5, then as new
+			{SortActive, []int{5, 3, 1, 6, 4, 7, 2}},
+		}
+		for _, tt := range tests {
+			want := make([]int, len(tt.want))
+			for i, n := range tt.want {
+				want[i] = ids[n-1]
+			}
+			for _, size := range []int{1, 2, 3, 7, 8} {
+				t.Run(fmt.Sprintf("%s by %d", tt.sort, size), func(t *testing.T) {
+					got := walkPages(t, func(cursor string) ([]int, PageInfo, error) {
+						posts, info, err := s.ListPosts(PostQuery{Sort: tt.sort}, Page{Size: size, Cursor: cursor})
+						ids := make([]int, len(posts))
+						for i, p := range posts {
+							ids[i] = p.ID
+						}
+						return ids, info, err
+					})
+					if fmt.Sprint(got) != fmt.Sprint(want) {
+						t.Errorf("pages = %v, want %v", got, want)
+					}
+				})
+			}
+		}
+
+		// A cursor only works in the listing and order it came from
+		_, newPage, err := s.ListPosts(PostQuery{Sort: SortNew}, Page{Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, order := range []PostSort{SortTop, SortControversial, SortActive} {
+			if _, _, err := s.ListPosts(PostQuery{Sort: order}, Page{Size: 2, Cursor: newPage.Next}); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("%s with a cursor from new: %v, want ErrInvalidCursor", order, err)
+			}
+		}
+		if _, _, err := s.CommentsForPost(ids[0], Page{Cursor: newPage.Next}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("comments with a cursor from posts: %v, want ErrInvalidCursor", err)
+		}
+		tampered := newPage.Next[:len(newPage.Next)-2]
+		if _, _, err := s.ListPosts(PostQuery{Sort: SortNew}, Page{Size: 2, Cursor: tampered}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("tampered cursor: %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestCommentPages(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		postID, err := s.CreatePost(alice.UUID, "Post", "text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Written within a second or two, so most share a created_at and
+		// only the ID keeps them apart
+		var want []int
+		for i := 0; i < 5; i++ {
+			id, err := s.AddComment(alice.UUID, postID, fmt.Sprintf("comment %d", i+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append([]int{id}, want...)
+		}
+
+		got := walkPages(t, func(cursor string) ([]int, PageInfo, error) {
+			comments, info, err := s.CommentsForPost(postID, Page{Size: 2, Cursor: cursor})
+			ids := make([]int, len(comments))
+			for i, c := range comments {
+				ids[i] = c.ID
+			}
+			return ids, info, err
+		})
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("pages = %v, want %v", got, want)
+		}
+
+		_, page, err := s.CommentsForPost(postID, Page{Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.ListPosts(PostQuery{}, Page{Cursor: page.Next}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("posts with a cursor from comments: %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestInvalidCursorHandlers(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, user := newTestUser(t, h, "alice", "correct horse 1")
+	for i := 0; i < 3; i++ {
+		createPost(t, m, user, fmt.Sprintf("Post %d", i+1), nil)
+	}
+	_, page, err := m.ListPosts(PostQuery{Sort: SortNew}, Page{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, alice.get("/home?limit=1&cursor="+page.Next), http.StatusOK)
+	wantStatus(t, alice.get("/home?sort=top&cursor="+page.Next), http.StatusBadRequest)
+	wantStatus(t, alice.get("/home?cursor=garbage"), http.StatusBadRequest)
+	wantStatus(t, alice.get(APIPrefix+"posts?sort=new&limit=1&cursor="+page.Next), http.StatusOK)
+	wantStatus(t, alice.get(APIPrefix+"posts?sort=active&cursor="+page.Next), http.StatusBadRequest)
+}
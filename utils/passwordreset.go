@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// PasswordResetTTL is how long a password reset link stays valid.
+var PasswordResetTTL = time.Hour
+
+// CreatePasswordReset stores a new single-use reset token for the user and
+// returns it. Only its hash is kept, and earlier unused links stop working.
+func (db *DataBase) CreatePasswordReset(user *User, r *http.Request) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_uuid = ? AND used_at IS NULL", sessionTime(now), user.UUID); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO password_resets (user_uuid, token_hash, created_at, expires_at, ip) VALUES (?, ?, ?, ?, ?)",
+		user.UUID, hashToken(token), sessionTime(now), sessionTime(now.Add(PasswordResetTTL)), clientIP(r))
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// SendPasswordResetEmail mails the user a link to choose a new password.
+func SendPasswordResetEmail(user *User, r *http.Request) error {
+	token, err := store.CreatePasswordReset(user, r)
+	if err != nil {
+		return err
+	}
+
+	link := BaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return Mailer.Send(Mail{
+		To:      user.Email,
+		Subject: "Reset your ForumHub password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. Choose a new one here:\n\n%s\n\nThe link works once and expires in %s. If you didn't ask for this, ignore this message; your password stays the same.\n",
+			user.Username, link, plural(int(PasswordResetTTL.Minutes()), "minute")),
+	})
+}
+
+// passwordReset looks up an unused reset token, returning its row ID and user.
+func passwordReset(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, token string) (int64, string, error) {
+	var id int64
+	var userUUID, expiresAt string
+	var usedAt sql.NullString
+	err := q.QueryRow("SELECT id, user_uuid, expires_at, used_at FROM password_resets WHERE token_hash = ?", hashToken(token)).
+		Scan(&id, &userUUID, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && usedAt.Valid) {
+		return 0, "", ErrInvalidToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if expires, err := parseTimestamp(expiresAt); err != nil || !expires.After(time.Now()) {
+		return 0, "", ErrTokenExpired
+	}
+	return id, userUUID, nil
+}
+
+// PasswordResetUser returns the user a reset token belongs to, if the token is still usable.
+func (db *DataBase) PasswordResetUser(token string) (*User, error) {
+	_, userUUID, err := passwordReset(db.Conn, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := db.UserByUUID(userUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	return user, err
+}
+
+// ResetPassword sets a new password using a reset token, uses the token up
+// and signs the user out everywhere. Receiving the link proves the user owns
+// the address, so the email counts as verified too.
+func ResetPassword(token, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user, err := store.UsePasswordReset(token, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.RevokeUserSessions(user.UUID, RevokePasswordReset); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UsePasswordReset does the database part of ResetPassword in one transaction.
+func (db *DataBase) UsePasswordReset(token, hash string) (*User, error) {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, userUUID, err := passwordReset(tx, token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE id = ?", sessionTime(time.Now()), id); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("UPDATE users SET password = ?, emailverified = 1 WHERE uuid = ? AND notregistered = 0", hash, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE uuid = ?", userUUID))
+	if err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+// ForgotPasswordHandler handles GET and POST /forgot-password
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderForgotPasswordForm(w, r, http.StatusOK, "", FieldErrors{})
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := NormalizeEmail(r.FormValue("email"))
+	if msg := ValidEmail(email); msg != "" {
+		renderForgotPasswordForm(w, r, http.StatusBadRequest, email, FieldErrors{"email": msg})
+		return
+	}
+
+	// Every request counts, so the form can't be used to flood an inbox
+	keys := throttleKeys(r, scopeReset, email)
+	wait, err := store.ThrottleWait(AuthThrottle, keys...)
+	if err != nil {
+		RenderError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		RenderTooManyRequests(w, wait)
+		return
+	}
+	if err := store.RecordFailure(AuthThrottle, keys...); err != nil {
+		log.Println("Error recording password reset request:", err)
+	}
+
+	// The reply is the same whether or not the account exists, so the form
+	// doesn't reveal which addresses are registered
+	user, err := store.FindUser("", email)
+	switch {
+	case err == nil && !user.NotRegistered:
+		if err := SendPasswordResetEmail(user, r); err != nil {
+			log.Println("Error sending password reset email:", err)
+		}
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		log.Println("Error looking up user for password reset:", err)
+	}
+
+	InitTemplate(w, r, "templates/notice.html", map[string]interface{}{
+		"Title":    "Check your inbox",
+		"Message":  "If an account uses " + email + ", we sent it a link to reset the password. The link expires in " + plural(int(PasswordResetTTL.Minutes()), "minute") + ".",
+		"Link":     "/login",
+		"LinkText": "Back to login",
+	})
+}
+
+// renderForgotPasswordForm shows forgot_password.html with the submitted email and any errors.
+func renderForgotPasswordForm(w http.ResponseWriter, r *http.Request, status int, email string, errs FieldErrors) {
+	w.WriteHeader(status)
+	InitTemplate(w, r, "templates/forgot_password.html", map[string]interface{}{
+		"Email":  email,
+		"Errors": errs,
+	})
+}
+
+// ResetPasswordHandler handles GET and POST /reset-password?token=...
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if _, err := store.PasswordResetUser(token); err != nil {
+			renderResetError(w, err)
+			return
+		}
+		renderResetPasswordForm(w, r, http.StatusOK, token, FieldErrors{})
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	errs := PasswordValidator.Validate(map[string]string{
+		"password":         password,
+		"confirm_password": confirmPassword,
+	})
+	if errs["confirm_password"] == "" && password != confirmPassword {
+		errs["confirm_password"] = "Passwords do not match"
+	}
+	if len(errs) > 0 {
+		renderResetPasswordForm(w, r, http.StatusBadRequest, token, errs)
+		return
+	}
+
+	user, err := ResetPassword(token, password)
+	if err != nil {
+		renderResetError(w, err)
+		return
+	}
+
+	// The old password may have been guessed; let the owner straight back in
+	if err := store.ClearFailures(throttleKeys(r, scopeLogin, user.Username, user.Email)[1:]...); err != nil {
+		log.Println("Error clearing login failures:", err)
+	}
+	if currentUUID(r) == user.UUID {
+		ClearUserCookie(w)
+	}
+
+	InitTemplate(w, r, "templates/notice.html", map[string]interface{}{
+		"Title":    "Password changed",
+		"Message":  "Your password has been reset and you have been signed out everywhere. Log in with your new password.",
+		"Link":     "/login",
+		"LinkText": "Log in",
+	})
+}
+
+// renderResetPasswordForm shows reset_password.html for a token with any per-field errors.
+func renderResetPasswordForm(w http.ResponseWriter, r *http.Request, status int, token string, errs FieldErrors) {
+	w.WriteHeader(status)
+	InitTemplate(w, r, "templates/reset_password.html", map[string]interface{}{
+		"Token":  token,
+		"Errors": errs,
+	})
+}
+
+// renderResetError explains why a reset link can't be used.
+func renderResetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		RenderError(w, "This password reset link has expired. Request a new one from the login page.", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidToken):
+		RenderError(w, "This password reset link is not valid or has already been used.", http.StatusBadRequest)
+	default:
+		log.Println("Error resetting password:", err)
+		RenderError(w, "Failed to reset password", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// postSelect is the shared SELECT for loading posts with their author and counts.
+// The counts and active_at are columns kept up to date by triggers, so a page
+// of posts is one query however many comments and votes they have. Callers
+// append their own WHERE/ORDER BY clauses; the counts are aliased so
+// postKeysets can sort on them.
+const postSelect = `
+    SELECT posts.id, posts.title, posts.content, posts.author_uuid,
+        CASE WHEN posts.deleted_at IS NULL THEN users.username ELSE 'deleted' END,
+        posts.created_at, posts.updated_at, posts.edited_at, posts.deleted_at IS NOT NULL,
+        posts.comment_count AS comment_count, posts.like_count AS like_count, posts.dislike_count AS dislike_count,
+        posts.active_at,
+        COALESCE((SELECT GROUP_CONCAT(categories.name, ',')
+            FROM post_categories
+            JOIN categories ON post_categories.category_id = categories.id
+            WHERE post_categories.post_id = posts.id), '')
+    FROM posts
+    JOIN users ON posts.author_uuid = users.uuid`
+
+// ErrEmptyPost is returned by CreatePost for a blank title or content.
+var ErrEmptyPost = errors.New("title and content cannot be empty")
+
+// postKeysets is the order of each PostSort; postKey must match it.
+var postKeysets = map[PostSort]keyset{
+	SortNew:           {name: "posts:new", columns: []string{"posts.created_at"}, id: "posts.id"},
+	SortTop:           {name: "posts:top", columns: []string{"like_count - dislike_count", "like_count"}, id: "posts.id"},
+	SortControversial: {name: "posts:controversial", columns: []string{"MIN(like_count, dislike_count)", "like_count + dislike_count"}, id: "posts.id"},
+	SortActive:        {name: "posts:active", columns: []string{"posts.active_at"}, id: "posts.id"},
+}
+
+// postKey returns a post's sort key in the given order, followed by its ID.
+func postKey(order PostSort, p Post) []interface{} {
+	id := int64(p.ID)
+	switch order {
+	case SortTop:
+		return []interface{}{int64(p.LikeCount - p.DislikeCount), int64(p.LikeCount), id}
+	case SortControversial:
+		return []interface{}{int64(min(p.LikeCount, p.DislikeCount)), int64(p.LikeCount + p.DislikeCount), id}
+	case SortActive:
+		return []interface{}{sessionTime(p.ActiveAt), id}
+	}
+	return []interface{}{sessionTime(p.CreatedAt), id}
+}
+
+// postKeyset returns the keyset of a sort order, defaulting to SortNew.
+func postKeyset(order PostSort) (keyset, PostSort) {
+	if k, ok := postKeysets[order]; ok {
+		return k, order
+	}
+	return postKeysets[SortNew], SortNew
+}
+
+// scanPost reads one row produced by postSelect.
+func scanPost(scan func(dest ...interface{}) error) (Post, error) {
+	var post Post
+	var categories, createdAt, updatedAt, activeAt string
+	var editedAt sql.NullString
+	err := scan(&post.ID, &post.Title, &post.Content, &post.Author.UUID, &post.Author.Username,
+		&createdAt, &updatedAt, &editedAt, &post.Deleted,
+		&post.CommentCount, &post.LikeCount, &post.DislikeCount, &activeAt, &categories)
+	if err != nil {
+		return Post{}, err
+	}
+	post.CreatedAt, _ = parseTimestamp(createdAt)
+	post.UpdatedAt, _ = parseTimestamp(updatedAt)
+	post.ActiveAt, _ = parseTimestamp(activeAt)
+	post.EditedAt = parseEditedAt(editedAt)
+	post.Categories = []Category{}
+	for _, name := range strings.Split(categories, ",") {
+		if name != "" {
+			post.Categories = append(post.Categories, Category{Name: name})
+		}
+	}
+	return post, nil
+}
+
+// ListPosts returns a page of the posts matching the query in q.Sort order,
+// newest first by default. It returns ErrInvalidCursor if page.Cursor doesn't
+// belong to this sort order.
+func (db *DataBase) ListPosts(q PostQuery, page Page) ([]Post, PageInfo, error) {
+	keys, order := postKeyset(q.Sort)
+	cur, err := keys.decode(page.Cursor)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	// Soft-deleted posts stay reachable by link but drop out of listings
+	where := []string{"posts.deleted_at IS NULL"}
+	var args []interface{}
+	if q.Category != "" {
+		where = append(where, `posts.id IN (
+            SELECT post_categories.post_id FROM post_categories
+            JOIN categories ON post_categories.category_id = categories.id
+            WHERE categories.name = ?)`)
+		args = append(args, q.Category)
+	}
+	if q.AuthorUUID != "" {
+		where = append(where, "posts.author_uuid = ?")
+		args = append(args, q.AuthorUUID)
+	}
+	if q.LikedBy != "" {
+		where = append(where, "posts.id IN (SELECT post_id FROM interactions WHERE user_uuid = ? AND liked = 1)")
+		args = append(args, q.LikedBy)
+	}
+
+	if cur != nil {
+		cond, keyArgs := keys.where(cur)
+		where = append(where, cond)
+		args = append(args, keyArgs...)
+	}
+	size := page.size()
+	query := postSelect + " WHERE " + strings.Join(where, " AND ") + " ORDER BY " + keys.orderBy(cur) + " LIMIT ?"
+	args = append(args, size+1)
+
+	rows, err := db.Conn.Query(query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(rows.Scan)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	posts, info := pageOf(keys, posts, size, cur, func(p Post) []interface{} { return postKey(order, p) })
+	return posts, info, nil
+}
+
+// GetPost loads a single post without its comments.
+func (db *DataBase) GetPost(postID int) (*Post, error) {
+	post, err := scanPost(db.Conn.QueryRow(postSelect+" WHERE posts.id = ?", postID).Scan)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// ListCategories returns every category, alphabetically.
+func (db *DataBase) ListCategories() ([]Category, error) {
+	rows, err := db.Conn.Query("SELECT id, name FROM categories WHERE name != '' ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// CreatePost inserts a post with its categories, creating any categories
+// that don't exist yet, and files it under forum when one is given.
+// Returns the new post ID, or ErrEmptyPost.
+func (db *DataBase) CreatePost(uuid, title, content string, categories []string, forum *SubForum) (int, error) {
+	if strings.TrimSpace(title) == "" || strings.TrimSpace(content) == "" {
+		return 0, ErrEmptyPost
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := sessionTime(time.Now())
+	res, err := tx.Exec("INSERT INTO posts (title, content, author_uuid, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", title, content, uuid, now, now)
+	if err != nil {
+		return 0, err
+	}
+	postID64, _ := res.LastInsertId()
+	postID := int(postID64)
+
+	if forum != nil {
+		if _, err := tx.Exec("INSERT INTO subforum_posts (subforum_id, post_id) VALUES (?, ?)", forum.ID, postID); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, cat := range categories {
+		cat = strings.TrimSpace(cat)
+		if cat == "" {
+			continue
+		}
+
+		// Ensure category exists
+		var catID int64
+		err := tx.QueryRow("SELECT id FROM categories WHERE name = ?", cat).Scan(&catID)
+		if errors.Is(err, sql.ErrNoRows) {
+			res, err := tx.Exec("INSERT INTO categories (name) VALUES (?)", cat)
+			if err != nil {
+				return 0, err
+			}
+			catID, _ = res.LastInsertId()
+		} else if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec("INSERT OR IGNORE INTO post_categories (post_id, category_id) VALUES (?, ?)", postID, catID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return postID, nil
+}
+
+// deletePostTx removes a post together with everything that references it
+// (comments, replies, votes, category and sub-forum links) inside tx, children first.
+func deletePostTx(tx *sql.Tx, postID int) error {
+	statements := []string{
+		"DELETE FROM replies WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comment_interactions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM revisions WHERE kind = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM revisions WHERE kind = 'post' AND target_id = ?",
+		"DELETE FROM interactions WHERE post_id = ?",
+		"DELETE FROM post_categories WHERE post_id = ?",
+		"DELETE FROM subforum_posts WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, postID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
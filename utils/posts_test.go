@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// setCommentCreated backdates a comment so tests can order activity.
+func setCommentCreated(t *testing.T, s Store, commentID int, at time.Time) {
+	t.Helper()
+	switch s := s.(type) {
+	case *MemoryStore:
+		s.mu.Lock()
+		s.comments[commentID].createdAt = at
+		s.mu.Unlock()
+	case *DataBase:
+		if _, err := s.Conn.Exec("UPDATE comments SET created_at = ? WHERE id = ?", sessionTime(at), commentID); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("can't backdate comments in a %T", s)
+	}
+}
+
+func TestActiveAt(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		postID, err := s.CreatePost(alice.UUID, "Post", "text", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantActive := func(what string, want time.Time) {
+			t.Helper()
+			post, err := s.GetPost(postID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sessionTime(post.ActiveAt); got != sessionTime(want) {
+				t.Errorf("active at %s after %s, want %s", got, what, sessionTime(want))
+			}
+		}
+		since := func(what string, start time.Time) {
+			t.Helper()
+			post, err := s.GetPost(postID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.ActiveAt.Before(start.Truncate(time.Second)) {
+				t.Errorf("active at %s after %s, want %s or later", sessionTime(post.ActiveAt), what, sessionTime(start))
+			}
+		}
+
+		setPostCreated(t, s, postID, day.AddDate(0, 0, -2))
+		wantActive("backdating the post", day.AddDate(0, 0, -2))
+
+		commentID, err := s.AddComment(bob.UUID, postID, "a comment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		setCommentCreated(t, s, commentID, day.AddDate(0, 0, -1))
+		wantActive("a comment", day.AddDate(0, 0, -1))
+
+		// Editing and soft-deleting aren't activity
+		if err := s.EditComment(commentID, bob.UUID, "edited"); err != nil {
+			t.Fatal(err)
+		}
+		wantActive("an edit", day.AddDate(0, 0, -1))
+
+		start := time.Now()
+		if err := s.AddReply(bob.UUID, commentID, 0, "a reply"); err != nil {
+			t.Fatal(err)
+		}
+		since("a reply", start)
+
+		// Erasing bob takes his comment and his own reply under it
+		if err := s.DeleteAccount(bob.UUID, DeleteErase); err != nil {
+			t.Fatal(err)
+		}
+		wantActive("erasing the commenter", day.AddDate(0, 0, -2))
+
+		start = time.Now()
+		if _, err := s.AddComment(alice.UUID, postID, "another"); err != nil {
+			t.Fatal(err)
+		}
+		since("another comment", start)
+	})
+}
+
+// countingDriver is the SQLite driver with every statement run through it
+// counted in queries.
+type countingDriver struct {
+	queries *atomic.Int64
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn.(*sqlite3.SQLiteConn), d.queries}, nil
+}
+
+// countingConn counts before handing each statement to SQLite.
+type countingConn struct {
+	*sqlite3.SQLiteConn
+	queries *atomic.Int64
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries.Add(1)
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries.Add(1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.queries.Add(1)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+var sqlQueries atomic.Int64
+
+func init() {
+	sql.Register("sqlite3_counting", countingDriver{&sqlQueries})
+}
+
+// seedPosts writes posts from+1 to to, a minute apart, in one transaction.
+// Every third post gets a comment and every fifth a like, so the counts and
+// active_at have something to do.
+func seedPosts(b *testing.B, d *DataBase, author string, from, to int) {
+	b.Helper()
+	tx, err := d.Conn.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := from + 1; i <= to; i++ {
+		at := sessionTime(start.Add(time.Duration(i) * time.Minute))
+		res, err := tx.Exec("INSERT INTO posts (title, content, author_uuid, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			fmt.Sprintf("Post %d", i), "some text to search", author, at, at)
+		if err != nil {
+			b.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		if _, err := tx.Exec("INSERT INTO post_categories (post_id, category_id) SELECT ?, id FROM categories WHERE name = 'go'", id); err != nil {
+			b.Fatal(err)
+		}
+		if i%3 == 0 {
+			if _, err := tx.Exec("INSERT INTO comments (content, comment_author_uuid, post_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+				"a comment", author, id, at, at); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if i%5 == 0 {
+			if _, err := tx.Exec("INSERT INTO interactions (user_uuid, post_id, liked, created_at, updated_at) VALUES (?, ?, 1, ?, ?)",
+				author, id, at, at); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkHomeFeed renders /home in every sort order over 10,000 posts.
+// It fails if a render runs more queries with 10,000 posts than with a
+// few, less than a page, which would mean something is loaded per post.
+func BenchmarkHomeFeed(b *testing.B) {
+	conn, err := sql.Open("sqlite3_counting", filepath.Join(b.TempDir(), "forum.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	d := &DataBase{Conn: conn}
+	if err := checkFTS5(conn); errors.Is(err, ErrNoFTS5) {
+		b.Skip("SQLite lacks FTS5; run the benchmark with -tags sqlite_fts5")
+	} else if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := d.Migrate(MigrationsDir); err != nil {
+		b.Fatal(err)
+	}
+	old := store
+	SetStore(d)
+	b.Cleanup(func() { SetStore(old) })
+
+	user := User{UUID: "alice-uuid", Username: "alice", Email: "alice@example.com", EmailVerified: true, Lastseen: time.Now()}
+	if err := d.CreateUser(user); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := d.Conn.Exec("INSERT INTO categories (name) VALUES ('go')"); err != nil {
+		b.Fatal(err)
+	}
+	session, err := d.CreateSession(user.UUID, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := newTestServer()
+	render := func(b *testing.B, order PostSort) int64 {
+		req := httptest.NewRequest(http.MethodGet, "/home?sort="+string(order), nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.Token})
+		rec := httptest.NewRecorder()
+		before := sqlQueries.Load()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("/home?sort=%s: status %d: %s", order, rec.Code, bodySummary(rec.Body.String()))
+		}
+		return sqlQueries.Load() - before
+	}
+	// queries counts a render after a warm-up one, which may touch the session
+	queries := func(b *testing.B, order PostSort) int64 {
+		render(b, order)
+		return render(b, order)
+	}
+
+	const small, large = 5, 10000
+	seedPosts(b, d, user.UUID, 0, small)
+	few := map[PostSort]int64{}
+	for _, order := range PostSorts {
+		few[order] = queries(b, order)
+	}
+	seedPosts(b, d, user.UUID, small, large)
+
+	for _, order := range PostSorts {
+		b.Run(string(order), func(b *testing.B) {
+			if n := queries(b, order); n != few[order] {
+				b.Fatalf("a render runs %d queries over %d posts but %d over %d", few[order], small, n, large)
+			}
+			start := sqlQueries.Load()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				render(b, order)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(sqlQueries.Load()-start)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxReplyDepth is how deeply replies may nest under a comment.
+// A direct reply to a comment has depth 1.
+var MaxReplyDepth = 4
+
+// Errors AddReply returns for a parent_id that doesn't fit the comment.
+var (
+	ErrReplyNotFound  = errors.New("reply not found")
+	ErrReplyElsewhere = errors.New("reply does not belong to this comment")
+	ErrReplyTooDeep   = errors.New("replies nested too deeply")
+)
+
+// AddReply stores a reply to a comment, or to another reply when parentID is non-zero.
+func (db *DataBase) AddReply(uuid string, commentID, parentID int, content string) error {
+	depth := 1
+	if parentID != 0 {
+		var parentComment, parentDepth int
+		err := db.Conn.QueryRow("SELECT comment_id, depth FROM replies WHERE id = ?", parentID).Scan(&parentComment, &parentDepth)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrReplyNotFound
+			}
+			return err
+		}
+		if parentComment != commentID {
+			return ErrReplyElsewhere
+		}
+		depth = parentDepth + 1
+	}
+	if depth > MaxReplyDepth {
+		return ErrReplyTooDeep
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	var parent interface{}
+	if parentID != 0 {
+		parent = parentID
+	}
+	now := sessionTime(time.Now())
+	_, err := db.Conn.Exec(
+		"INSERT INTO replies (content, reply_author_uuid, comment_id, parent_id, depth, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		content, uuid, commentID, parent, depth, now, now,
+	)
+	return err
+}
+
+// RepliesForComments loads every reply under the given comments of a post
+// and returns the reply trees keyed by comment ID.
+func (db *DataBase) RepliesForComments(postID int, commentIDs []int) (map[int][]Reply, error) {
+	if len(commentIDs) == 0 {
+		return map[int][]Reply{}, nil
+	}
+	args := make([]interface{}, len(commentIDs))
+	for i, id := range commentIDs {
+		args[i] = id
+	}
+	rows, err := db.Conn.Query(`
+        SELECT replies.id, replies.content, users.username, replies.comment_id,
+            COALESCE(replies.parent_id, 0), replies.depth, replies.created_at
+        FROM replies
+        JOIN users ON replies.reply_author_uuid = users.uuid
+        WHERE replies.comment_id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")+`)
+        ORDER BY replies.id ASC
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flat []Reply
+	for rows.Next() {
+		var reply Reply
+		var commentID int
+		var createdAt string
+		if err := rows.Scan(&reply.ID, &reply.Content, &reply.Author.Username, &commentID, &reply.ParentID, &reply.Depth, &createdAt); err != nil {
+			return nil, err
+		}
+		reply.CreatedAt, _ = parseTimestamp(createdAt)
+		reply.Comment = Comment{ID: commentID, Post: Post{ID: postID}}
+		reply.CanReply = reply.Depth < MaxReplyDepth
+		flat = append(flat, reply)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildReplyTree(flat), nil
+}
+
+// buildReplyTree nests a flat, ID-ordered list of replies under their parents.
+func buildReplyTree(flat []Reply) map[int][]Reply {
+	children := make(map[int][]int) // parent reply ID -> child indexes
+	var roots []int
+	for i, reply := range flat {
+		if reply.ParentID == 0 {
+			roots = append(roots, i)
+		} else {
+			children[reply.ParentID] = append(children[reply.ParentID], i)
+		}
+	}
+
+	var build func(i int) Reply
+	build = func(i int) Reply {
+		reply := flat[i]
+		for _, c := range children[reply.ID] {
+			reply.Replies = append(reply.Replies, build(c))
+		}
+		return reply
+	}
+
+	tree := make(map[int][]Reply)
+	for _, i := range roots {
+		tree[flat[i].Comment.ID] = append(tree[flat[i].Comment.ID], build(i))
+	}
+	return tree
+}
+
+// closeReplies hides the reply forms in a thread whose comment or post was deleted.
+func closeReplies(replies []Reply) {
+	for i := range replies {
+		replies[i].CanReply = false
+		closeReplies(replies[i].Replies)
+	}
+}
+
+// handleReply handles POST /post/{id}/reply; called through RequireRegistered
+func handleReply(w http.ResponseWriter, r *http.Request, postID int) {
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	uuid := CurrentUser(r).UUID
+
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		RenderError(w, "Missing comment ID", http.StatusBadRequest)
+		return
+	}
+
+	// The comment must belong to the post in the URL
+	comment, err := store.GetComment(commentID)
+	if err != nil || comment.Post.ID != postID {
+		RenderError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if gone, err := commentGone(comment); err != nil {
+		RenderError(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	} else if gone != "" {
+		RenderError(w, gone, http.StatusGone)
+		return
+	}
+
+	parentID := 0
+	if raw := r.FormValue("parent_id"); raw != "" {
+		parentID, err = strconv.Atoi(raw)
+		if err != nil {
+			RenderError(w, "Invalid reply ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	content := r.FormValue("reply")
+	if content == "" {
+		RenderError(w, "Reply cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	err = store.AddReply(uuid, commentID, parentID, content)
+	switch {
+	case errors.Is(err, ErrReplyNotFound), errors.Is(err, ErrReplyElsewhere):
+		RenderError(w, "The reply you answered is not part of this comment", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrReplyTooDeep):
+		RenderError(w, fmt.Sprintf("Replies cannot be nested more than %d levels deep", MaxReplyDepth), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("Error adding reply:", err)
+		RenderError(w, "Failed to add reply", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
+}
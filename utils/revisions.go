@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDeleted is returned when editing or deleting a soft-deleted post or comment.
+var ErrDeleted = errors.New("already deleted")
+
+// Revision kinds and reasons, as stored in the revisions table.
+const (
+	RevisionPost    = "post"
+	RevisionComment = "comment"
+
+	RevisionEdit   = "edit"
+	RevisionDelete = "delete"
+)
+
+// parseEditedAt turns a nullable edited_at column into a time, or nil if never edited.
+func parseEditedAt(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t, err := parseTimestamp(value.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// recordRevision saves the current version of a post or comment before it
+// changes. It returns sql.ErrNoRows if the row doesn't exist and ErrDeleted
+// if it was soft-deleted already.
+func recordRevision(tx *sql.Tx, kind string, id int, editorUUID, reason string, now time.Time) error {
+	query := "SELECT NULL, content, deleted_at FROM comments WHERE id = ?"
+	if kind == RevisionPost {
+		query = "SELECT title, content, deleted_at FROM posts WHERE id = ?"
+	}
+
+	var title, deletedAt sql.NullString
+	var content string
+	if err := tx.QueryRow(query, id).Scan(&title, &content, &deletedAt); err != nil {
+		return err
+	}
+	if deletedAt.Valid {
+		return ErrDeleted
+	}
+
+	_, err := tx.Exec(`
+        INSERT INTO revisions (kind, target_id, title, content, editor_uuid, reason, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, kind, id, title, content, editorUUID, reason, sessionTime(now))
+	return err
+}
+
+// revise records a revision and applies update in one transaction. update
+// takes args, then the current time, then id as numbered parameters.
+func (db *DataBase) revise(kind string, id int, editorUUID, reason, update string, args ...interface{}) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := recordRevision(tx, kind, id, editorUUID, reason, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(update, append(append(args, sessionTime(now)), id)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EditPost replaces a post's title and content, keeping the old version.
+func (db *DataBase) EditPost(postID int, editorUUID, title, content string) error {
+	return db.revise(RevisionPost, postID, editorUUID, RevisionEdit,
+		"UPDATE posts SET title = ?1, content = ?2, edited_at = ?3, updated_at = ?3 WHERE id = ?4", title, content)
+}
+
+// SoftDeletePost replaces a post with a "[deleted]" placeholder, keeping the
+// old version. Its comments stay, so the thread still makes sense.
+func (db *DataBase) SoftDeletePost(postID int, editorUUID string) error {
+	return db.revise(RevisionPost, postID, editorUUID, RevisionDelete,
+		"UPDATE posts SET title = ?1, content = ?2, deleted_at = ?3, updated_at = ?3 WHERE id = ?4", deletedContent, deletedContent)
+}
+
+// EditComment replaces a comment's content, keeping the old version.
+func (db *DataBase) EditComment(commentID int, editorUUID, content string) error {
+	return db.revise(RevisionComment, commentID, editorUUID, RevisionEdit,
+		"UPDATE comments SET content = ?1, edited_at = ?2, updated_at = ?2 WHERE id = ?3", content)
+}
+
+// SoftDeleteComment replaces a comment with a "[deleted]" placeholder,
+// keeping the old version. Replies to it stay in place.
+func (db *DataBase) SoftDeleteComment(commentID int, editorUUID string) error {
+	return db.revise(RevisionComment, commentID, editorUUID, RevisionDelete,
+		"UPDATE comments SET content = ?1, deleted_at = ?2, updated_at = ?2 WHERE id = ?3", deletedContent)
+}
+
+// PostRevisions returns the revisions of a post and of its comments, newest first.
+func (db *DataBase) PostRevisions(postID int) ([]Revision, error) {
+	rows, err := db.Conn.Query(`
+        SELECT revisions.id, revisions.kind, revisions.target_id, COALESCE(revisions.title, ''),
+            revisions.content, users.username, revisions.reason, revisions.created_at,
+            COALESCE(CASE revisions.kind
+                WHEN 'post' THEN (SELECT author_uuid FROM posts WHERE id = revisions.target_id)
+                ELSE (SELECT comment_author_uuid FROM comments WHERE id = revisions.target_id)
+            END, '')
+        FROM revisions
+        JOIN users ON revisions.editor_uuid = users.uuid
+        WHERE (revisions.kind = 'post' AND revisions.target_id = ?1)
+           OR (revisions.kind = 'comment' AND revisions.target_id IN (SELECT id FROM comments WHERE post_id = ?1))
+        ORDER BY revisions.id DESC
+    `, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		var createdAt string
+		if err := rows.Scan(&rev.ID, &rev.Kind, &rev.TargetID, &rev.Title, &rev.Content, &rev.Editor.Username, &rev.Reason, &createdAt, &rev.Author); err != nil {
+			return nil, err
+		}
+		rev.CreatedAt, _ = parseTimestamp(createdAt)
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// handleEditPost handles GET/POST /post/{id}/edit; wrapped in RequireRegistered
+func handleEditPost(w http.ResponseWriter, r *http.Request, post *Post) {
+	uuid := CurrentUser(r).UUID
+	if post.Author.UUID != uuid {
+		RenderError(w, "Only the author can edit this post", http.StatusForbidden)
+		return
+	}
+	if post.Deleted {
+		RenderError(w, "This post has been deleted", http.StatusGone)
+		return
+	}
+
+	action := fmt.Sprintf("/post/%d/edit", post.ID)
+	switch r.Method {
+	case http.MethodGet:
+		renderEditForm(w, r, http.StatusOK, action, post.ID, true, post.Title, post.Content, "")
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	if title == "" || content == "" {
+		renderEditForm(w, r, http.StatusBadRequest, action, post.ID, true, title, content, "Title and content cannot be empty")
+		return
+	}
+
+	if err := store.EditPost(post.ID, uuid, title, content); err != nil {
+		renderRevisionError(w, err, "edit post")
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", post.ID), http.StatusSeeOther)
+}
+
+// handleDeletePost handles GET/POST /post/{id}/delete: GET asks for
+// confirmation and POST deletes; wrapped in RequireRegistered
+func handleDeletePost(w http.ResponseWriter, r *http.Request, post *Post) {
+	uuid := CurrentUser(r).UUID
+	if post.Author.UUID != uuid {
+		RenderError(w, "Only the author can delete this post", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if post.Deleted {
+			RenderError(w, "This post has been deleted", http.StatusGone)
+			return
+		}
+		renderDeleteForm(w, r, fmt.Sprintf("/post/%d/delete", post.ID), post.ID, 0, post.Title, post.Content)
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := store.SoftDeletePost(post.ID, uuid); err != nil {
+		renderRevisionError(w, err, "delete post")
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", post.ID), http.StatusSeeOther)
+}
+
+// handleEditComment handles GET/POST /post/{id}/comment-edit?comment_id=...; wrapped in RequireRegistered
+func handleEditComment(w http.ResponseWriter, r *http.Request, postID int) {
+	comment, ok := authoredComment(w, r, postID, "edit")
+	if !ok {
+		return
+	}
+	if comment.Deleted {
+		RenderError(w, "This comment has been deleted", http.StatusGone)
+		return
+	}
+
+	action := fmt.Sprintf("/post/%d/comment-edit?comment_id=%d", postID, comment.ID)
+	switch r.Method {
+	case http.MethodGet:
+		renderEditForm(w, r, http.StatusOK, action, postID, false, "", comment.Content, "")
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	content := strings.TrimSpace(r.FormValue("content"))
+	if content == "" {
+		renderEditForm(w, r, http.StatusBadRequest, action, postID, false, "", content, "Comment cannot be empty")
+		return
+	}
+
+	if err := store.EditComment(comment.ID, comment.Author.UUID, content); err != nil {
+		renderRevisionError(w, err, "edit comment")
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
+}
+
+// handleDeleteComment handles GET/POST /post/{id}/comment-delete?comment_id=...:
+// GET asks for confirmation and POST deletes; wrapped in RequireRegistered
+func handleDeleteComment(w http.ResponseWriter, r *http.Request, postID int) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	comment, ok := authoredComment(w, r, postID, "delete")
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if comment.Deleted {
+			RenderError(w, "This comment has been deleted", http.StatusGone)
+			return
+		}
+		renderDeleteForm(w, r, fmt.Sprintf("/post/%d/comment-delete", postID), postID, comment.ID, "", comment.Content)
+		return
+	}
+
+	if err := store.SoftDeleteComment(comment.ID, comment.Author.UUID); err != nil {
+		renderRevisionError(w, err, "delete comment")
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
+}
+
+// commentGone says why a comment takes no new votes or replies: it or its
+// post has been soft-deleted. It returns "" when both are still live.
+func commentGone(comment *Comment) (string, error) {
+	if comment.Deleted {
+		return "This comment has been deleted", nil
+	}
+	post, err := store.GetPost(comment.Post.ID)
+	if err != nil {
+		return "", err
+	}
+	if post.Deleted {
+		return "This post has been deleted", nil
+	}
+	return "", nil
+}
+
+// authoredComment loads the comment named by comment_id, checking that it
+// belongs to the post and was written by the current user.
+func authoredComment(w http.ResponseWriter, r *http.Request, postID int, verb string) (*Comment, bool) {
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		RenderError(w, "Missing comment ID", http.StatusBadRequest)
+		return nil, false
+	}
+	comment, err := store.GetComment(commentID)
+	if err != nil || comment.Post.ID != postID {
+		RenderError(w, "Comment not found", http.StatusNotFound)
+		return nil, false
+	}
+	if comment.Author.UUID != CurrentUser(r).UUID {
+		RenderError(w, "Only the author can "+verb+" this comment", http.StatusForbidden)
+		return nil, false
+	}
+	return comment, true
+}
+
+// handleHistory handles GET /post/{id}/history; wrapped in RequireRegistered.
+// Earlier versions may hold things their authors took back, so moderators
+// (admins of the post's sub-forum) see all of them and everyone else only
+// those of the post or comments they wrote themselves. That way posts
+// outside any sub-forum still have a history their authors can read.
+func handleHistory(w http.ResponseWriter, r *http.Request, post *Post) {
+	if r.Method != http.MethodGet {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	viewer := CurrentUser(r).UUID
+	moderator, err := store.ModeratesPost(viewer, post.ID)
+	if err != nil {
+		RenderError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	revisions, err := store.PostRevisions(post.ID)
+	if err != nil {
+		RenderError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	if !moderator {
+		own := revisions[:0]
+		for _, rev := range revisions {
+			if rev.Author == viewer {
+				own = append(own, rev)
+			}
+		}
+		revisions = own
+		if len(revisions) == 0 && post.Author.UUID != viewer {
+			RenderError(w, "Only moderators and authors can see the edit history", http.StatusForbidden)
+			return
+		}
+	}
+
+	InitTemplate(w, r, "templates/history.html", map[string]interface{}{
+		"Post":      post,
+		"Revisions": revisions,
+		"Moderator": moderator,
+	})
+}
+
+// renderEditForm shows edit.html for a post (with a title field) or a comment.
+func renderEditForm(w http.ResponseWriter, r *http.Request, status int, action string, postID int, isPost bool, title, content, errMsg string) {
+	w.WriteHeader(status)
+	InitTemplate(w, r, "templates/edit.html", map[string]interface{}{
+		"Action":  action,
+		"PostID":  postID,
+		"IsPost":  isPost,
+		"Title":   title,
+		"Content": content,
+		"Error":   errMsg,
+	})
+}
+
+// renderDeleteForm shows delete.html, which asks before deleting a post or,
+// when commentID is set, a comment.
+func renderDeleteForm(w http.ResponseWriter, r *http.Request, action string, postID, commentID int, title, content string) {
+	InitTemplate(w, r, "templates/delete.html", map[string]interface{}{
+		"Action":    action,
+		"PostID":    postID,
+		"CommentID": commentID,
+		"IsPost":    commentID == 0,
+		"Title":     title,
+		"Content":   content,
+	})
+}
+
+// renderRevisionError reports a failed edit or delete.
+func renderRevisionError(w http.ResponseWriter, err error, what string) {
+	switch {
+	case errors.Is(err, ErrDeleted):
+		RenderError(w, "It has already been deleted", http.StatusGone)
+	case errors.Is(err, sql.ErrNoRows):
+		RenderError(w, "Not found", http.StatusNotFound)
+	default:
+		RenderError(w, "Failed to "+what, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchLimit is how many results a search shows, best matches first.
+var SearchLimit = 50
+
+// ErrNoFTS5 means the binary's SQLite lacks FTS5, which search is built on.
+var ErrNoFTS5 = errors.New("SQLite was built without FTS5; build with -tags sqlite_fts5 (see the Makefile)")
+
+// checkFTS5 returns ErrNoFTS5 unless SQLite was compiled with FTS5.
+func checkFTS5(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) error {
+	var fts5 bool
+	if err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		return ErrNoFTS5
+	}
+	return nil
+}
+
+// SearchQuery is a full-text search narrowed by optional filters; zero
+// filters are ignored.
+type SearchQuery struct {
+	Text     string
+	Category string
+	Author   string    // username of whoever wrote the post or comment
+	From     time.Time // created on or after this day
+	To       time.Time // created on or before this day
+}
+
+// SearchResult is a post or a comment matching a search.
+type SearchResult struct {
+	PostID    int
+	CommentID int // 0 when the post itself matched
+	Title     string
+	Snippet   template.HTML // escaped text around the match, hits in <mark>
+	Author    string
+	CreatedAt time.Time
+}
+
+// Markers snippet() puts around hits; highlight turns them into <mark> after escaping.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// ftsQuery turns what the user typed into an FTS5 query matching every
+// word, each also as a prefix. Operators and quotes are dropped so no input
+// is a syntax error. It returns "" if there are no words.
+func ftsQuery(text string) string {
+	words := searchTerms(text)
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// searchTerms splits text into the words a search looks for: runs of
+// letters and digits, with everything else taken as a separator.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight escapes a snippet and marks the hits.
+func highlight(snippet string) template.HTML {
+	s := html.EscapeString(snippet)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	s = strings.ReplaceAll(s, markEnd, "</mark>")
+	return template.HTML(s)
+}
+
+// Search returns the posts and comments matching q, best first. Titles
+// weigh more than content. Results under soft-deleted posts are left out.
+func (db *DataBase) Search(q SearchQuery) ([]SearchResult, error) {
+	match := ftsQuery(q.Text)
+	if match == "" {
+		return nil, nil
+	}
+
+	where := []string{"posts.deleted_at IS NULL"}
+	args := []interface{}{markStart, markEnd, match}
+	if q.Category != "" {
+		where = append(where, `posts.id IN (
+            SELECT post_categories.post_id FROM post_categories
+            JOIN categories ON post_categories.category_id = categories.id
+            WHERE categories.name = ?)`)
+		args = append(args, q.Category)
+	}
+	if q.Author != "" {
+		where = append(where, "users.username = ? COLLATE NOCASE")
+		args = append(args, q.Author)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "COALESCE(comments.created_at, posts.created_at) >= ?")
+		args = append(args, sessionTime(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "COALESCE(comments.created_at, posts.created_at) < ?")
+		args = append(args, sessionTime(q.To.AddDate(0, 0, 1)))
+	}
+	args = append(args, SearchLimit)
+
+	rows, err := db.Conn.Query(`
+        WITH hits AS (
+            SELECT rowid, snippet(search_index, -1, ?, ?, '…', 24) AS snippet,
+                bm25(search_index, 5.0, 1.0) AS rank
+            FROM search_index WHERE search_index MATCH ?
+        )
+        SELECT posts.id, COALESCE(comments.id, 0), posts.title, hits.snippet, users.username,
+            COALESCE(comments.created_at, posts.created_at)
+        FROM hits
+        LEFT JOIN comments ON hits.rowid % 2 = 1 AND comments.id = hits.rowid / 2
+        JOIN posts ON posts.id = COALESCE(comments.post_id, hits.rowid / 2)
+        JOIN users ON users.uuid = COALESCE(comments.comment_author_uuid, posts.author_uuid)
+        WHERE `+strings.Join(where, " AND ")+`
+        ORDER BY hits.rank, hits.rowid DESC
+        LIMIT ?
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var res SearchResult
+		var snippet, createdAt string
+		if err := rows.Scan(&res.PostID, &res.CommentID, &res.Title, &snippet, &res.Author, &createdAt); err != nil {
+			return nil, err
+		}
+		res.Snippet = highlight(snippet)
+		res.CreatedAt, _ = parseTimestamp(createdAt)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// SearchHandler handles GET /search?q=&category=&author=&from=&to=
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	q := SearchQuery{
+		Text:     strings.TrimSpace(params.Get("q")),
+		Category: params.Get("category"),
+		Author:   strings.TrimSpace(params.Get("author")),
+	}
+	errs := FieldErrors{}
+	for _, field := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := params.Get(field.name); value != "" {
+			day, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				errs[field.name] = "Use a date like 2025-01-31"
+				continue
+			}
+			*field.dst = day
+		}
+	}
+	if errs["to"] == "" && !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		errs["to"] = "The end date is before the start date"
+	}
+
+	categories, err := store.ListCategories()
+	if err != nil {
+		RenderError(w, "Failed to load categories", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Query":      q.Text,
+		"Category":   q.Category,
+		"Author":     q.Author,
+		"From":       params.Get("from"),
+		"To":         params.Get("to"),
+		"Categories": categories,
+		"Errors":     errs,
+		"Searched":   false,
+	}
+
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		InitTemplate(w, r, "templates/search.html", data)
+		return
+	}
+
+	if q.Text != "" {
+		results, err := store.Search(q)
+		if err != nil {
+			log.Println("Error searching:", err)
+			RenderError(w, "Search failed", http.StatusInternalServerError)
+			return
+		}
+		data["Searched"] = true
+		data["Results"] = results
+		data["Limited"] = len(results) == SearchLimit
+	}
+	InitTemplate(w, r, "templates/search.html", data)
+}
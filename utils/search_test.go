@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"words", "hello world", `"hello"* "world"*`},
+		{"extra spaces", "  hello   world ", `"hello"* "world"*`},
+		{"double quotes", `say "hi there"`, `"say"* "hi"* "there"*`},
+		{"unbalanced quote", `say "hi`, `"say"* "hi"*`},
+		{"star", "go* *lang", `"go"* "lang"*`},
+		{"NEAR", "cats NEAR dogs", `"cats"* "NEAR"* "dogs"*`},
+		{"NEAR group", "NEAR(cats dogs, 5)", `"NEAR"* "cats"* "dogs"* "5"*`},
+		{"AND OR NOT", "cats AND dogs OR mice NOT rats", `"cats"* "AND"* "dogs"* "OR"* "mice"* "NOT"* "rats"*`},
+		{"minus", "-spam eggs", `"spam"* "eggs"*`},
+		{"hyphenated word", "e-mail", `"e"* "mail"*`},
+		{"column filter", "title:secret", `"title"* "secret"*`},
+		{"column list", "{title content}:x", `"title"* "content"* "x"*`},
+		{"caret and plus", "^start +more", `"start"* "more"*`},
+		{"parentheses", "(a OR b)", `"a"* "OR"* "b"*`},
+		{"Unicode letters", "Straße café ünïcode", `"Straße"* "café"* "ünïcode"*`},
+		{"non-Latin script", "привет мир", `"привет"* "мир"*`},
+		{"digits", "go 1.24", `"go"* "1"* "24"*`},
+		{"only operators", `"*-:^()`, ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ftsQuery(tt.text); got != tt.want {
+				t.Errorf("ftsQuery(%q) = %s, want %s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// searchHit identifies a search result: the post, and the comment if one matched.
+type searchHit struct{ post, comment int }
+
+func hits(results []SearchResult) []searchHit {
+	list := make([]searchHit, len(results))
+	for i, r := range results {
+		list[i] = searchHit{r.PostID, r.CommentID}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].post != list[j].post {
+			return list[i].post < list[j].post
+		}
+		return list[i].comment < list[j].comment
+	})
+	return list
+}
+
+func TestSearch(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+
+		post := func(author *User, title, content string, categories ...string) int {
+			t.Helper()
+			id, err := s.CreatePost(author.UUID, title, content, categories, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		comment := func(author *User, postID int, content string) int {
+			t.Helper()
+			id, err := s.AddComment(author.UUID, postID, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		river := post(alice, "Walks", "We went near the river and then to the sea", "outdoors")
+		secret := post(alice, "The title is secret", "nothing to see")
+		hidden := post(bob, "Secret plans", "spam and eggs")
+		bobOnRiver := comment(bob, river, "The river was cold")
+		aliceOnHidden := comment(alice, hidden, "More spam please")
+		if err := s.AddReply(alice.UUID, bobOnRiver, 0, "freezing, wasn't it"); err != nil {
+			t.Fatal(err)
+		}
+		gone := post(bob, "River cruise", "sold out")
+		if err := s.SoftDeletePost(gone, bob.UUID); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name string
+			q    SearchQuery
+			want []searchHit
+		}{
+			{"plain word", SearchQuery{Text: "river"}, []searchHit{{river, 0}, {river, bobOnRiver}}},
+			{"prefix", SearchQuery{Text: "riv"}, []searchHit{{river, 0}, {river, bobOnRiver}}},
+			{"case-insensitive", SearchQuery{Text: "RIVER"}, []searchHit{{river, 0}, {river, bobOnRiver}}},
+			{"every word must match", SearchQuery{Text: "river sea"}, []searchHit{{river, 0}}},
+			{"NEAR is a word", SearchQuery{Text: "NEAR river"}, []searchHit{{river, 0}}},
+			{"AND is a word", SearchQuery{Text: "spam AND eggs"}, []searchHit{{hidden, 0}}},
+			{"minus doesn't exclude", SearchQuery{Text: "-spam"}, []searchHit{{hidden, 0}, {hidden, aliceOnHidden}}},
+			{"colon isn't a column filter", SearchQuery{Text: "title:secret"}, []searchHit{{secret, 0}}},
+			{"stray quote", SearchQuery{Text: `secret"`}, []searchHit{{secret, 0}, {hidden, 0}}},
+			{"star", SearchQuery{Text: "eg*"}, []searchHit{{hidden, 0}}},
+			{"only operators", SearchQuery{Text: `"*-:`}, nil},
+			{"author", SearchQuery{Text: "secret", Author: "bob"}, []searchHit{{hidden, 0}}},
+			{"author in another case", SearchQuery{Text: "secret", Author: "ALICE"}, []searchHit{{secret, 0}}},
+			{"author of a comment", SearchQuery{Text: "river", Author: "bob"}, []searchHit{{river, bobOnRiver}}},
+			{"author of a comment, not the post", SearchQuery{Text: "spam", Author: "alice"}, []searchHit{{hidden, aliceOnHidden}}},
+			{"unknown author", SearchQuery{Text: "river", Author: "nobody"}, nil},
+			{"category", SearchQuery{Text: "river", Category: "outdoors"}, []searchHit{{river, 0}, {river, bobOnRiver}}},
+			{"deleted post", SearchQuery{Text: "cruise"}, nil},
+			{"replies aren't indexed", SearchQuery{Text: "freezing"}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				results, err := s.Search(tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := hits(results); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("Search(%+v) = %v, want %v", tt.q, got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestSearchHandler(t *testing.T) {
+	h, m := newMemoryServer(t)
+	_, alice := newTestUser(t, h, "alice", "correct horse 1")
+	createPost(t, m, alice, "Gopher <tips>", nil)
+
+	anon := &testClient{t: t, handler: h}
+	rec := anon.get("/search?" + url.Values{"q": {"gopher"}}.Encode())
+	wantStatus(t, rec, http.StatusOK)
+	wantBody(t, rec, "Gopher &lt;tips&gt;", "<mark>Gopher</mark>")
+
+	rec = anon.get("/search?" + url.Values{"q": {"gopher"}, "author": {"bob"}}.Encode())
+	wantStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "<mark>") {
+		t.Error("author filter let another author's post through")
+	}
+
+	wantStatus(t, anon.get("/search?q=x&from=yesterday"), http.StatusBadRequest)
+}
+
+// TestSearchMigration rolls the search index back and forth: the down
+// migration drops it, and the up migration indexes whatever was written
+// in between.
+func TestSearchMigration(t *testing.T) {
+	d := openTestDB(t)
+	alice := addUser(t, d, "alice")
+	before, err := d.CreatePost(alice.UUID, "Before", "written with the index", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Rollback(MigrationsDir, version-15); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := d.Conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'search%'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d search tables and triggers left after rolling back", n)
+	}
+
+	during, err := d.CreatePost(alice.UUID, "During", "written without the index", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Migrate(MigrationsDir); err != nil {
+		t.Fatal(err)
+	}
+	results, err := d.Search(SearchQuery{Text: "written"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hits(results), []searchHit{{before, 0}, {during, 0}}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("search after migrating again = %v, want %v", got, want)
+	}
+}
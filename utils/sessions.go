@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrNoSession       = errors.New("no session")
+	ErrSessionExpired  = errors.New("session expired")
+	ErrSessionRevoked  = errors.New("session revoked")
+	ErrSessionReplaced = errors.New("session replaced by a newer login")
+)
+
+// Reasons stored in sessions.revoked_reason.
+const (
+	RevokeLogout         = "logout"
+	RevokeReplaced       = "replaced"
+	RevokePasswordReset  = "password_reset"
+	RevokePasswordChange = "password_change"
+	RevokeUpgraded       = "upgraded"
+)
+
+// EventSessionReplaced is recorded when a login signs out the user's older sessions.
+const EventSessionReplaced = "session_replaced"
+
+// maxUserAgent caps how much of the User-Agent header is stored per session.
+const maxUserAgent = 255
+
+// newSessionToken returns 32 random bytes encoded for use in a cookie or form.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what the sessions table stores, so a leaked database
+// doesn't hand out working cookies.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionTime formats times in UTC so they compare correctly as text.
+func sessionTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newSession prepares a session for the user with fresh tokens, recording
+// where the request came from. Stores fill in its ID when they save it.
+func newSession(uuid string, r *http.Request) (*Session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	return &Session{
+		Token:     token,
+		UserUUID:  uuid,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTimeout),
+		IP:        clientIP(r),
+		UserAgent: userAgent,
+		CSRFToken: csrfToken,
+	}, nil
+}
+
+// sessionStatus returns nil if a stored session can still be used, or why
+// not. revokedReason is "" for a session that was never revoked.
+func sessionStatus(session *Session, revokedReason string) error {
+	switch {
+	case revokedReason == RevokeReplaced:
+		return ErrSessionReplaced
+	case revokedReason != "":
+		return ErrSessionRevoked
+	case time.Now().After(session.ExpiresAt):
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// CreateSession starts a new session for the user, recording where it came from.
+func (db *DataBase) CreateSession(uuid string, r *http.Request) (*Session, error) {
+	session, err := newSession(uuid, r)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	res, err := db.Conn.Exec(
+		"INSERT INTO sessions (token_hash, user_uuid, created_at, expires_at, ip, user_agent, csrf_token) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		hashToken(session.Token), uuid, sessionTime(session.CreatedAt), sessionTime(session.ExpiresAt), session.IP, session.UserAgent, session.CSRFToken,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	session.ID = int(id)
+	return session, nil
+}
+
+// SessionByToken looks up the session behind a cookie token. Expired and
+// revoked sessions are returned together with ErrSessionExpired,
+// ErrSessionReplaced or ErrSessionRevoked so callers can still see whose
+// session it was.
+func (db *DataBase) SessionByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+
+	var session Session
+	var createdAt, expiresAt string
+	var revokedAt, revokedReason sql.NullString
+	err := db.Conn.QueryRow(
+		"SELECT id, user_uuid, created_at, expires_at, ip, user_agent, csrf_token, revoked_at, revoked_reason FROM sessions WHERE token_hash = ?",
+		hashToken(token),
+	).Scan(&session.ID, &session.UserUUID, &createdAt, &expiresAt, &session.IP, &session.UserAgent, &session.CSRFToken, &revokedAt, &revokedReason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Token = token
+	session.CreatedAt, _ = parseTimestamp(createdAt)
+	session.ExpiresAt, err = parseTimestamp(expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+	if revokedAt.Valid {
+		reason = revokedReason.String
+	}
+	return &session, sessionStatus(&session, reason)
+}
+
+// TouchSession pushes the session's expiry forward and updates the user's lastseen.
+func (db *DataBase) TouchSession(session *Session) error {
+	now := time.Now()
+	session.ExpiresAt = now.Add(SessionTimeout)
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	if _, err := db.Conn.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?", sessionTime(session.ExpiresAt), session.ID); err != nil {
+		return err
+	}
+	_, err := db.Conn.Exec("UPDATE users SET lastseen = ? WHERE uuid = ?", now.Format(time.RFC3339), session.UserUUID)
+	return err
+}
+
+// RevokeSession invalidates the session behind token.
+func (db *DataBase) RevokeSession(token, reason string) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	_, err := db.Conn.Exec("UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE token_hash = ? AND revoked_at IS NULL",
+		sessionTime(time.Now()), reason, hashToken(token))
+	return err
+}
+
+// RevokeUserSessions invalidates every live session the user has and
+// returns how many were revoked.
+func (db *DataBase) RevokeUserSessions(uuid, reason string) (int64, error) {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	now := sessionTime(time.Now())
+	res, err := db.Conn.Exec("UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE user_uuid = ? AND revoked_at IS NULL AND expires_at > ?",
+		now, reason, uuid, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeOtherSessions signs the session's user out everywhere else and
+// returns how many sessions that ended.
+func (db *DataBase) RevokeOtherSessions(session *Session, reason string) (int64, error) {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	now := sessionTime(time.Now())
+	res, err := db.Conn.Exec("UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE user_uuid = ? AND id <> ? AND revoked_at IS NULL AND expires_at > ?",
+		now, reason, session.UserUUID, session.ID, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RecordSessionEvent adds an entry to the session audit trail.
+func (db *DataBase) RecordSessionEvent(session *Session, event string) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	_, err := db.Conn.Exec(
+		"INSERT INTO session_events (user_uuid, session_id, event, ip, user_agent, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		session.UserUUID, session.ID, event, session.IP, session.UserAgent, sessionTime(time.Now()),
+	)
+	return err
+}
+
+// StartSession creates a session for the user and hands its token to the browser.
+// Only one session per user is allowed, so any older ones are signed out
+// and the takeover is recorded.
+func StartSession(w http.ResponseWriter, r *http.Request, uuid string) error {
+	replaced, err := store.RevokeUserSessions(uuid, RevokeReplaced)
+	if err != nil {
+		return err
+	}
+
+	session, err := store.CreateSession(uuid, r)
+	if err != nil {
+		return err
+	}
+
+	if replaced > 0 {
+		log.Printf("Signed out %d older session(s) of user %s after login from %s", replaced, uuid, session.IP)
+		if err := store.RecordSessionEvent(session, EventSessionReplaced); err != nil {
+			log.Println("Error recording session event:", err)
+		}
+	}
+
+	SetUserCookie(w, session.Token, session.ExpiresAt)
+	return nil
+}
+
+// CheckSession validates the request's session and slides its expiry forward.
+// An expired session clears the cookie and removes the guest account behind it.
+// A replaced session keeps its cookie so /login can tell the user why.
+func CheckSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	session, err := store.SessionByToken(GetSessionToken(r))
+	if errors.Is(err, ErrSessionExpired) {
+		ClearUserCookie(w)
+		store.DeleteUser(session.UserUUID)
+		return nil, err
+	}
+	if errors.Is(err, ErrSessionReplaced) {
+		return nil, err
+	}
+	if err != nil {
+		ClearUserCookie(w)
+		return nil, err
+	}
+
+	if err := store.TouchSession(session); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	SetUserCookie(w, session.Token, session.ExpiresAt)
+	return session, nil
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SettingsHandler handles GET and POST /settings; wrapped in RequireGuestOrUser.
+// Members who haven't verified their email can still use it, e.g. to fix a typo
+// in the address. Each form posts an "action" of username, email or password.
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	if user.NotRegistered {
+		RenderError(w, "Guests have no account settings. Register to get an account.", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderSettings(w, r, http.StatusOK, *user, "", "", FieldErrors{})
+		return
+	case http.MethodPost:
+	default:
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	action := r.FormValue("action")
+	updated := *user
+	var errs FieldErrors
+	var notice string
+
+	switch action {
+	case "username":
+		updated.Username = NormalizeUsername(r.FormValue("username"))
+		errs = UsernameValidator.Validate(map[string]string{"username": updated.Username})
+		if len(errs) == 0 && updated.Username == user.Username {
+			errs["username"] = "That is already your username"
+		}
+		notice = "Your username is now " + updated.Username + "."
+
+	case "email":
+		updated.Email = NormalizeEmail(r.FormValue("email"))
+		errs = EmailValidator.Validate(map[string]string{"email": updated.Email})
+		if len(errs) == 0 && updated.Email == NormalizeEmail(user.Email) {
+			errs["email"] = "That is already your email address"
+		}
+		notice = "We sent a verification link to " + updated.Email + ". You can post again once it is confirmed."
+
+	case "password":
+		password := r.FormValue("password")
+		confirmPassword := r.FormValue("confirm_password")
+		errs = PasswordValidator.Validate(map[string]string{
+			"password":         password,
+			"confirm_password": confirmPassword,
+		})
+		if errs["confirm_password"] == "" && password != confirmPassword {
+			errs["confirm_password"] = "Passwords do not match"
+		}
+		if len(errs) == 0 {
+			hash, err := HashPassword(password)
+			if err != nil {
+				log.Println("Error hashing password:", err)
+				RenderError(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			updated.Password = hash
+		}
+		notice = "Your password has been changed and your other devices have been signed out."
+
+	default:
+		RenderError(w, "Unknown settings action", http.StatusBadRequest)
+		return
+	}
+
+	if len(errs) > 0 {
+		renderSettings(w, r, http.StatusBadRequest, updated, action, "", errs)
+		return
+	}
+
+	// Changing the email or password needs the current password, so an
+	// unattended session can't be used to take the account over
+	if action == "email" || action == "password" {
+		ok, done := checkCurrentPassword(w, r, user, r.FormValue("current_password"))
+		if done {
+			return
+		}
+		if !ok {
+			renderSettings(w, r, http.StatusBadRequest, updated, action, "", FieldErrors{"current_password": "Current password is incorrect"})
+			return
+		}
+	}
+
+	if action == "email" {
+		updated.EmailVerified = false
+	}
+	err := store.UpdateUser(updated)
+	if errors.Is(err, ErrUserExists) {
+		if err := store.RecordFailure(AuthThrottle, throttleKeys(r, scopeSettings, user.UUID)...); err != nil {
+			log.Println("Error recording failed settings change:", err)
+		}
+		updated.EmailVerified = user.EmailVerified
+		errs = FieldErrors{"email": "That email is already registered"}
+		if errors.Is(err, ErrUsernameTaken) {
+			errs = FieldErrors{"username": "That username is taken"}
+		}
+		renderSettings(w, r, http.StatusBadRequest, updated, action, "", errs)
+		return
+	}
+	if err != nil {
+		log.Println("Error updating user:", err)
+		RenderError(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	switch action {
+	case "email":
+		if err := SendVerificationEmail(&updated); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	case "password":
+		if _, err := store.RevokeOtherSessions(CurrentSession(r), RevokePasswordChange); err != nil {
+			log.Println("Error revoking other sessions:", err)
+		}
+	}
+
+	renderSettings(w, r, http.StatusOK, updated, "", notice, FieldErrors{})
+}
+
+// checkCurrentPassword compares password with the user's, throttling wrong
+// guesses like failed logins. done is true when a response was already written.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *User, password string) (ok, done bool) {
+	keys := throttleKeys(r, scopeSettings, user.UUID)
+	wait, err := store.ThrottleWait(AuthThrottle, keys...)
+	if err != nil {
+		RenderError(w, "Internal server error", http.StatusInternalServerError)
+		return false, true
+	}
+	if wait > 0 {
+		RenderTooManyRequests(w, wait)
+		return false, true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if err := store.RecordFailure(AuthThrottle, keys...); err != nil {
+			log.Println("Error recording failed password check:", err)
+		}
+		return false, false
+	}
+	return true, false
+}
+
+// renderSettings shows settings.html. action names the form errs belong to.
+func renderSettings(w http.ResponseWriter, r *http.Request, status int, user User, action, notice string, errs FieldErrors) {
+	w.WriteHeader(status)
+	InitTemplate(w, r, "templates/settings.html", map[string]interface{}{
+		"Username":   user.Username,
+		"Email":      user.Email,
+		"Unverified": !user.EmailVerified,
+		"Action":     action,
+		"Notice":     notice,
+		"Errors":     errs,
+	})
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSettingsNeedCurrentPassword(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		h := newTestServer()
+		alice, user := newTestUser(t, h, "alice", "correct horse 1")
+
+		for _, form := range []url.Values{
+			{"action": {"email"}, "email": {"mallory@example.com"}, "current_password": {"wrong"}},
+			{"action": {"password"}, "password": {"correct horse 2"}, "confirm_password": {"correct horse 2"}, "current_password": {"wrong"}},
+		} {
+			rec := alice.post("/settings", form)
+			wantStatus(t, rec, http.StatusBadRequest)
+			wantBody(t, rec, "Current password is incorrect")
+		}
+
+		got, err := s.UserByUUID(user.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != user.Email || !got.EmailVerified || got.Password != user.Password {
+			t.Errorf("user after wrong passwords = %+v, want unchanged", got)
+		}
+	})
+}
+
+func TestSettingsChangeEmail(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		h := newTestServer()
+		alice, user := newTestUser(t, h, "alice", "correct horse 1")
+
+		form := url.Values{"action": {"email"}, "email": {"Alice@Example.org"}, "current_password": {"correct horse 1"}}
+		rec := alice.post("/settings", form)
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "We sent a verification link to alice@example.org")
+
+		got, err := s.UserByUUID(user.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != "alice@example.org" || got.EmailVerified {
+			t.Errorf("user after changing email = %s, verified %v; want alice@example.org, unverified", got.Email, got.EmailVerified)
+		}
+		if mail := lastMail(t, "alice@example.org"); !strings.Contains(mail.Body, BaseURL+"/verify?token=") {
+			t.Errorf("mail to the new address = %q, want a verification link", mail.Body)
+		}
+	})
+}
+
+func TestSettingsChangePassword(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		h := newTestServer()
+		alice, user := newTestUser(t, h, "alice", "correct horse 1")
+		// Logging in signs other sessions out, so the second device's
+		// session is made directly
+		session, err := s.CreateSession(user.UUID, httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		laptop := &testClient{t: t, handler: h, token: session.Token}
+		wantStatus(t, laptop.get("/home"), http.StatusOK)
+
+		form := url.Values{
+			"action":           {"password"},
+			"password":         {"correct horse 2"},
+			"confirm_password": {"correct horse 2"},
+			"current_password": {"correct horse 1"},
+		}
+		rec := alice.post("/settings", form)
+		wantStatus(t, rec, http.StatusOK)
+		wantBody(t, rec, "Your password has been changed")
+
+		got, err := s.UserByUUID(user.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(got.Password), []byte("correct horse 2")) != nil {
+			t.Error("new password doesn't match the stored hash")
+		}
+		wantStatus(t, alice.get("/home"), http.StatusOK)
+		rec = laptop.get("/home")
+		wantStatus(t, rec, http.StatusUnauthorized)
+		wantBody(t, rec, "Session expired")
+	})
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"net/http"
+	"time"
+)
+
+// PostStore loads and saves posts and their categories.
+type PostStore interface {
+	// ListPosts returns a page of posts and the cursors around it;
+	// ErrInvalidCursor if the cursor doesn't fit q.Sort.
+	ListPosts(q PostQuery, page Page) ([]Post, PageInfo, error)
+	// GetPost returns the post without its comments; sql.ErrNoRows if it doesn't exist.
+	GetPost(postID int) (*Post, error)
+	CreatePost(uuid, title, content string, categories []string, forum *SubForum) (int, error)
+	// EditPost and SoftDeletePost keep the previous version as a Revision;
+	// both return ErrDeleted once the post has been soft-deleted.
+	EditPost(postID int, editorUUID, title, content string) error
+	SoftDeletePost(postID int, editorUUID string) error
+	ListCategories() ([]Category, error)
+}
+
+// CommentStore loads and saves comments and their replies.
+type CommentStore interface {
+	// CommentsForPost returns a page of the post's comments, newest first,
+	// with their reply trees; ErrInvalidCursor for a cursor of another listing.
+	CommentsForPost(postID int, page Page) ([]Comment, PageInfo, error)
+	// GetComment returns a comment with only Post.ID set; sql.ErrNoRows if it doesn't exist.
+	GetComment(commentID int) (*Comment, error)
+	AddComment(uuid string, postID int, content string) (int, error)
+	AddReply(uuid string, commentID, parentID int, content string) error
+	// EditComment and SoftDeleteComment work like EditPost and SoftDeletePost.
+	EditComment(commentID int, editorUUID, content string) error
+	SoftDeleteComment(commentID int, editorUUID string) error
+}
+
+// RevisionStore loads the history kept by edits and soft deletes.
+type RevisionStore interface {
+	// PostRevisions returns the revisions of a post and of its comments, newest first.
+	PostRevisions(postID int) ([]Revision, error)
+}
+
+// UserStore loads and saves users.
+type UserStore interface {
+	// UserByUUID returns sql.ErrNoRows if the user doesn't exist.
+	UserByUUID(uuid string) (*User, error)
+	// FindUser looks a user up by username or email; sql.ErrNoRows if neither matches.
+	FindUser(username, email string) (*User, error)
+	CreateUser(user User) error
+	// UpdateUser saves the username, email, password and verification state
+	// of an existing user; ErrUsernameTaken or ErrEmailTaken on a clash.
+	UpdateUser(user User) error
+	// UpgradeGuest registers a guest in place; ErrNotGuest if uuid isn't a guest.
+	UpgradeGuest(uuid, username, email, password string) (*User, error)
+	MarkEmailVerified(uuid string) error
+	// DeleteUser removes a guest and its sessions; registered users are kept.
+	DeleteUser(uuid string) error
+}
+
+// SessionStore keeps the server-side sessions behind login cookies.
+type SessionStore interface {
+	CreateSession(uuid string, r *http.Request) (*Session, error)
+	// SessionByToken returns ErrNoSession for an unknown token. Expired and
+	// revoked sessions come back with ErrSessionExpired, ErrSessionReplaced
+	// or ErrSessionRevoked.
+	SessionByToken(token string) (*Session, error)
+	TouchSession(session *Session) error
+	RevokeSession(token, reason string) error
+	// RevokeUserSessions and RevokeOtherSessions return how many live sessions they ended.
+	RevokeUserSessions(uuid, reason string) (int64, error)
+	RevokeOtherSessions(session *Session, reason string) (int64, error)
+	RecordSessionEvent(session *Session, event string) error
+}
+
+// SubForumStore loads and saves sub-forums and what their admins do.
+type SubForumStore interface {
+	CreateSubForum(name, creatorUUID string) (*SubForum, error)
+	// SubForumByName returns the sub-forum with its admins; sql.ErrNoRows if it doesn't exist.
+	SubForumByName(name string) (*SubForum, error)
+	SubForumNames() ([]string, error)
+	// SubForumPosts lists the posts filed under a sub-forum that aren't
+	// deleted, pinned ones first and then newest first.
+	SubForumPosts(forumID int) ([]SubForumPost, error)
+	AddSubForumAdmin(forumID int, username string) error
+	SetPinned(forumID, postID int, pinned bool) error
+	InSubForum(forumID, postID int) (bool, error)
+	ModeratesPost(uuid string, postID int) (bool, error)
+}
+
+// SecretStore keeps server-side keys, such as the one signing email links.
+type SecretStore interface {
+	// Secret returns the named key, creating a random one on first use.
+	Secret(name string) ([]byte, error)
+}
+
+// PasswordResetStore keeps the single-use password reset tokens.
+type PasswordResetStore interface {
+	CreatePasswordReset(user *User, r *http.Request) (string, error)
+	// PasswordResetUser returns ErrInvalidToken or ErrTokenExpired for a token that can't be used.
+	PasswordResetUser(token string) (*User, error)
+	// UsePasswordReset sets the password hash of the token's user, verifies
+	// their email and uses the token up.
+	UsePasswordReset(token, hash string) (*User, error)
+}
+
+// ThrottleStore counts failed attempts per key for a ThrottlePolicy.
+type ThrottleStore interface {
+	// ThrottleWait returns how long to wait before another attempt on any of keys; 0 means go ahead.
+	ThrottleWait(p ThrottlePolicy, keys ...string) (time.Duration, error)
+	RecordFailure(p ThrottlePolicy, keys ...string) error
+	ClearFailures(keys ...string) error
+}
+
+// AccountStore exports and deletes whole accounts.
+type AccountStore interface {
+	ExportUserData(uuid string) (*UserExport, error)
+	// DeleteAccount removes a registered user; sql.ErrNoRows if there is none.
+	DeleteAccount(uuid, mode string) error
+}
+
+// SearchStore runs full-text searches over posts and comments; replies
+// aren't searched.
+type SearchStore interface {
+	// Search returns at most SearchLimit results, best first.
+	Search(q SearchQuery) ([]SearchResult, error)
+}
+
+// InteractionStore records likes and dislikes.
+type InteractionStore interface {
+	// VotePost and VoteComment toggle a vote and return the user's new vote.
+	VotePost(uuid string, postID int, kind VoteKind) (VoteKind, error)
+	VoteComment(uuid string, commentID int, kind VoteKind) (VoteKind, error)
+	PostVote(uuid string, postID int) (VoteKind, error)
+	// CommentVotes returns the user's votes on a post's comments, keyed by comment ID.
+	CommentVotes(uuid string, postID int) (map[int]VoteKind, error)
+}
+
+// Store is everything the handlers need from persistence.
+type Store interface {
+	PostStore
+	CommentStore
+	UserStore
+	InteractionStore
+	RevisionStore
+	SessionStore
+	SubForumStore
+	SecretStore
+	PasswordResetStore
+	ThrottleStore
+	AccountStore
+	SearchStore
+}
+
+var (
+	_ Store = (*DataBase)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// store backs the handlers. DBInitialize points it at the SQLite database;
+// SetStore swaps it, e.g. for a MemoryStore in tests.
+var store Store
+
+// SetStore replaces the store used by the handlers.
+func SetStore(s Store) {
+	store = s
+}
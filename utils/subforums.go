@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// subForumName restricts sub-forum names to something that is safe in a URL.
+var subForumName = regexp.MustCompile(`^[a-z0-9_-]{3,32}$`)
+
+var (
+	ErrSubForumName   = errors.New("name must be 3-32 characters of a-z, 0-9, '-' or '_'")
+	ErrSubForumExists = errors.New("a sub-forum with this name already exists")
+	ErrNoSuchUser     = errors.New("no registered user with that username")
+)
+
+// normalizeSubForumName lowercases and trims a sub-forum name, returning
+// ErrSubForumName if it isn't allowed.
+func normalizeSubForumName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !subForumName.MatchString(name) {
+		return "", ErrSubForumName
+	}
+	return name, nil
+}
+
+// CreateSubForum stores a new sub-forum and makes its creator the first admin.
+// It returns ErrSubForumName or ErrSubForumExists for a name it can't use.
+func (db *DataBase) CreateSubForum(name, creatorUUID string) (*SubForum, error) {
+	name, err := normalizeSubForumName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM subforums WHERE name = ?", name).Scan(&exists)
+	if err == nil {
+		return nil, ErrSubForumExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	res, err := tx.Exec("INSERT INTO subforums (name, creator_uuid) VALUES (?, ?)", name, creatorUUID)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return nil, ErrSubForumExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+
+	if _, err := tx.Exec("INSERT INTO subforum_admins (subforum_id, user_uuid) VALUES (?, ?)", id, creatorUUID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &SubForum{ID: int(id), Name: name, Creator: User{UUID: creatorUUID}}, nil
+}
+
+// SubForumByName loads a sub-forum with its creator and admins.
+// Posts are not loaded.
+func (db *DataBase) SubForumByName(name string) (*SubForum, error) {
+	var forum SubForum
+	err := db.Conn.QueryRow(`
+        SELECT subforums.id, subforums.name, users.uuid, users.username
+        FROM subforums
+        JOIN users ON subforums.creator_uuid = users.uuid
+        WHERE subforums.name = ?
+    `, name).Scan(&forum.ID, &forum.Name, &forum.Creator.UUID, &forum.Creator.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.Query(`
+        SELECT users.uuid, users.username
+        FROM subforum_admins
+        JOIN users ON subforum_admins.user_uuid = users.uuid
+        WHERE subforum_admins.subforum_id = ?
+        ORDER BY users.username ASC
+    `, forum.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var admin User
+		if err := rows.Scan(&admin.UUID, &admin.Username); err != nil {
+			return nil, err
+		}
+		forum.Admins = append(forum.Admins, admin)
+	}
+	return &forum, rows.Err()
+}
+
+// SubForumNames lists every sub-forum name, alphabetically.
+func (db *DataBase) SubForumNames() ([]string, error) {
+	rows, err := db.Conn.Query("SELECT name FROM subforums ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SubForumPosts lists a sub-forum's posts that aren't deleted, pinned ones first.
+func (db *DataBase) SubForumPosts(forumID int) ([]SubForumPost, error) {
+	rows, err := db.Conn.Query(`
+        SELECT posts.id, posts.title, posts.content, users.uuid, users.username, subforum_posts.pinned, posts.created_at
+        FROM posts
+        JOIN users ON posts.author_uuid = users.uuid
+        JOIN subforum_posts ON posts.id = subforum_posts.post_id
+        WHERE subforum_posts.subforum_id = ? AND posts.deleted_at IS NULL
+        ORDER BY subforum_posts.pinned DESC, posts.created_at DESC, posts.id DESC
+    `, forumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []SubForumPost
+	for rows.Next() {
+		var p SubForumPost
+		var createdAt string
+		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.Author.UUID, &p.Author.Username, &p.Pinned, &createdAt); err != nil {
+			return nil, err
+		}
+		p.CreatedAt, _ = parseTimestamp(createdAt)
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// IsAdmin reports whether the user administers this sub-forum.
+func (f *SubForum) IsAdmin(uuid string) bool {
+	for _, admin := range f.Admins {
+		if admin.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPinned pins or unpins a post within a sub-forum.
+func (db *DataBase) SetPinned(forumID, postID int, pinned bool) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	res, err := db.Conn.Exec("UPDATE subforum_posts SET pinned = ? WHERE subforum_id = ? AND post_id = ?", pinned, forumID, postID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("post is not in this sub-forum")
+	}
+	return nil
+}
+
+// InSubForum reports whether a post is filed under the given sub-forum.
+func (db *DataBase) InSubForum(forumID, postID int) (bool, error) {
+	var exists int
+	err := db.Conn.QueryRow("SELECT 1 FROM subforum_posts WHERE subforum_id = ? AND post_id = ?", forumID, postID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ModeratesPost reports whether the user administers the sub-forum the post is filed under.
+func (db *DataBase) ModeratesPost(uuid string, postID int) (bool, error) {
+	var exists int
+	err := db.Conn.QueryRow(`
+        SELECT 1 FROM subforum_admins
+        JOIN subforum_posts ON subforum_posts.subforum_id = subforum_admins.subforum_id
+        WHERE subforum_posts.post_id = ? AND subforum_admins.user_uuid = ?
+    `, postID, uuid).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AddSubForumAdmin grants admin rights in a sub-forum to a user by username,
+// matched like FindUser regardless of case; ErrNoSuchUser if no registered
+// user has that name.
+func (db *DataBase) AddSubForumAdmin(forumID int, username string) error {
+	user, err := db.FindUser(username, "")
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.NotRegistered) {
+		return ErrNoSuchUser
+	}
+	if err != nil {
+		return err
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	_, err = db.Conn.Exec("INSERT OR IGNORE INTO subforum_admins (subforum_id, user_uuid) VALUES (?, ?)", forumID, user.UUID)
+	return err
+}
+
+// CreateSubForumHandler handles GET/POST /create-forum; wrapped in RequireRegistered
+func CreateSubForumHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := CurrentUser(r).UUID
+
+	if r.Method == http.MethodGet {
+		InitTemplate(w, r, "templates/create_forum.html", nil)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		forum, err := store.CreateSubForum(r.FormValue("name"), uuid)
+		switch {
+		case errors.Is(err, ErrSubForumName):
+			RenderError(w, "Sub-forum names must be 3-32 characters of a-z, 0-9, '-' or '_'", http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrSubForumExists):
+			RenderError(w, "A sub-forum with this name already exists", http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Println("Error creating sub-forum:", err)
+			RenderError(w, "Failed to create sub-forum", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/f/"+forum.Name, http.StatusSeeOther)
+		return
+	}
+
+	RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// SubForumHandler handles /f/{name} and the admin actions under it:
+// POST /f/{name}/pin, /f/{name}/unpin, /f/{name}/remove and /f/{name}/admins.
+// Removing a post soft-deletes it on the admin's behalf.
+func SubForumHandler(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/f/"), "/")
+
+	forum, err := store.SubForumByName(name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			RenderError(w, "Sub-forum not found", http.StatusNotFound)
+			return
+		}
+		RenderError(w, "Failed to load sub-forum", http.StatusInternalServerError)
+		return
+	}
+
+	uuid := currentUUID(r)
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		renderSubForum(w, r, forum, uuid)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if uuid == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !forum.IsAdmin(uuid) {
+		RenderError(w, "Only sub-forum admins can do that", http.StatusForbidden)
+		return
+	}
+
+	if action == "admins" {
+		// Only the creator hands out admin rights
+		if forum.Creator.UUID != uuid {
+			RenderError(w, "Only the sub-forum creator can add admins", http.StatusForbidden)
+			return
+		}
+		err := store.AddSubForumAdmin(forum.ID, strings.TrimSpace(r.FormValue("username")))
+		if errors.Is(err, ErrNoSuchUser) {
+			RenderError(w, "No registered user with that username", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("Error adding sub-forum admin:", err)
+			RenderError(w, "Failed to add admin", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/f/"+forum.Name, http.StatusSeeOther)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		RenderError(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+
+	// Admins may only act on posts filed under their own sub-forum
+	inForum, err := store.InSubForum(forum.ID, postID)
+	if err != nil {
+		RenderError(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+	if !inForum {
+		RenderError(w, "Post is not in this sub-forum", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "pin", "unpin":
+		err = store.SetPinned(forum.ID, postID, action == "pin")
+	case "remove":
+		// Soft-deleted, so the history survives for the other moderators
+		err = store.SoftDeletePost(postID, uuid)
+		if errors.Is(err, ErrDeleted) {
+			RenderError(w, "This post has already been removed", http.StatusGone)
+			return
+		}
+	default:
+		RenderError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		RenderError(w, fmt.Sprintf("Failed to %s post", action), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/f/"+forum.Name, http.StatusSeeOther)
+}
+
+// renderSubForum lists a sub-forum's posts, pinned posts first.
+func renderSubForum(w http.ResponseWriter, r *http.Request, forum *SubForum, uuid string) {
+	posts, err := store.SubForumPosts(forum.ID)
+	if err != nil {
+		RenderError(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Forum":     forum,
+		"Posts":     posts,
+		"IsAdmin":   forum.IsAdmin(uuid),
+		"IsCreator": forum.Creator.UUID == uuid,
+	}
+	InitTemplate(w, r, "templates/subforum.html", data)
+}
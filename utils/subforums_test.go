@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAddSubForumAdmin(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		addUser(t, s, "Bob")
+		guest := User{UUID: "guest-uuid", Username: "guest_1234", NotRegistered: true}
+		if err := s.CreateUser(guest); err != nil {
+			t.Fatal(err)
+		}
+		forum, err := s.CreateSubForum("gophers", alice.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"bob", " BOB "} {
+			if err := s.AddSubForumAdmin(forum.ID, name); err != nil {
+				t.Errorf("AddSubForumAdmin(%q) = %v, want Bob added", name, err)
+			}
+		}
+		for _, name := range []string{"nobody", "guest_1234", ""} {
+			if err := s.AddSubForumAdmin(forum.ID, name); !errors.Is(err, ErrNoSuchUser) {
+				t.Errorf("AddSubForumAdmin(%q) = %v, want ErrNoSuchUser", name, err)
+			}
+		}
+
+		forum, err = s.SubForumByName("gophers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var admins []string
+		for _, admin := range forum.Admins {
+			admins = append(admins, admin.Username)
+		}
+		if len(admins) != 2 || !containsString(admins, "alice") || !containsString(admins, "Bob") {
+			t.Errorf("admins = %v, want alice and Bob once each", admins)
+		}
+	})
+}
+
+func TestSubForumErrors(t *testing.T) {
+	h, m := newMemoryServer(t)
+	alice, _ := newTestUser(t, h, "alice", "correct horse 1")
+	newTestUser(t, h, "bob", "correct horse 2")
+
+	wantStatus(t, alice.post("/create-forum", url.Values{"name": {"gophers"}}), http.StatusSeeOther)
+	rec := alice.post("/create-forum", url.Values{"name": {"Gophers"}})
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "A sub-forum with this name already exists")
+	rec = alice.post("/create-forum", url.Values{"name": {"no"}})
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "Sub-forum names must be 3-32 characters")
+
+	rec = alice.post("/f/gophers/admins", url.Values{"username": {"nobody"}})
+	wantStatus(t, rec, http.StatusBadRequest)
+	wantBody(t, rec, "No registered user with that username")
+	wantStatus(t, alice.post("/f/gophers/admins", url.Values{"username": {"bob"}}), http.StatusSeeOther)
+
+	useStore(t, brokenStore{m})
+	rec = alice.post("/create-forum", url.Values{"name": {"rustaceans"}})
+	wantStatus(t, rec, http.StatusInternalServerError)
+	wantHidden(t, rec)
+	rec = alice.post("/f/gophers/admins", url.Values{"username": {"bob"}})
+	wantStatus(t, rec, http.StatusInternalServerError)
+	wantHidden(t, rec)
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThrottlePolicy decides how long a key must wait after failed attempts.
+type ThrottlePolicy struct {
+	FreeFailures    int           // failures allowed before any delay
+	BaseDelay       time.Duration // delay after the first counted failure, doubled for each one after
+	MaxDelay        time.Duration
+	LockoutAfter    int // failures that lock the key out entirely
+	LockoutDuration time.Duration
+	Window          time.Duration    // failures older than this are forgotten
+	Now             func() time.Time // the clock; nil means time.Now
+}
+
+// AuthThrottle slows down failed logins and sign-ups, keyed by IP and by account.
+var AuthThrottle = ThrottlePolicy{
+	FreeFailures:    3,
+	BaseDelay:       2 * time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+func (p ThrottlePolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// delay is how long to wait after the given number of failures.
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	counted := failures - p.FreeFailures
+	if counted <= 0 {
+		return 0
+	}
+	d := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(counted-1)))
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// Throttle scopes keep the failures of different forms apart.
+const (
+	scopeLogin    = "login"
+	scopeRegister = "register"
+	scopeReset    = "reset"
+	scopeSettings = "settings"
+)
+
+// throttleScopes lists every scope, so an account's keys can all be found.
+var throttleScopes = []string{scopeLogin, scopeRegister, scopeReset, scopeSettings}
+
+// throttleKeys builds the keys an attempt is counted against: the client's IP
+// and every account identifier it named.
+func throttleKeys(r *http.Request, scope string, accounts ...string) []string {
+	return append([]string{scope + ":ip:" + clientIP(r)}, accountThrottleKeys(scope, accounts...)...)
+}
+
+// accountThrottleKeys builds the per-account keys of throttleKeys.
+func accountThrottleKeys(scope string, accounts ...string) []string {
+	var keys []string
+	for _, account := range accounts {
+		if account = strings.ToLower(strings.TrimSpace(account)); account != "" {
+			keys = append(keys, scope+":account:"+account)
+		}
+	}
+	return keys
+}
+
+// userThrottleKeys lists every key the user's username, email or UUID can
+// have been counted under, in any scope.
+func userThrottleKeys(user *User) []string {
+	var keys []string
+	for _, scope := range throttleScopes {
+		keys = append(keys, accountThrottleKeys(scope, user.Username, user.Email, user.UUID)...)
+	}
+	return keys
+}
+
+// throttleEntry is what is remembered about the failures against one key.
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// wait is how long an attempt on the key has to wait at now; 0 means go ahead.
+func (p ThrottlePolicy) wait(e throttleEntry, now time.Time) time.Duration {
+	if e.lockedUntil.After(now) {
+		return e.lockedUntil.Sub(now)
+	}
+	if now.Sub(e.lastFailure) > p.Window {
+		return 0
+	}
+	if next := e.lastFailure.Add(p.delay(e.failures)); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// fail returns the entry after one more failure at now, locking the key
+// out once it reaches the policy's LockoutAfter.
+func (p ThrottlePolicy) fail(e throttleEntry, now time.Time) throttleEntry {
+	// Start counting again once the old failures have aged out
+	if now.Sub(e.lastFailure) > p.Window {
+		e.failures = 0
+	}
+	e.failures++
+	e.lastFailure = now
+	if p.LockoutAfter > 0 && e.failures >= p.LockoutAfter {
+		e.lockedUntil = now.Add(p.LockoutDuration)
+		e.failures = 0 // a fresh start once the lockout ends
+	}
+	return e
+}
+
+// loadThrottleEntry reads the entry for key; a key without failures comes back zero.
+func loadThrottleEntry(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, key string) (throttleEntry, error) {
+	var e throttleEntry
+	var lastFailure string
+	var lockedUntil sql.NullString
+	err := q.QueryRow("SELECT failures, last_failure, locked_until FROM auth_throttle WHERE key = ?", key).
+		Scan(&e.failures, &lastFailure, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return throttleEntry{}, nil
+	}
+	if err != nil {
+		return throttleEntry{}, err
+	}
+	// Unreadable times count as long ago
+	e.lastFailure, _ = parseTimestamp(lastFailure)
+	if lockedUntil.Valid {
+		e.lockedUntil, _ = parseTimestamp(lockedUntil.String)
+	}
+	return e, nil
+}
+
+// ThrottleWait returns how long the caller must wait before another attempt
+// on any of keys is allowed; 0 means go ahead.
+func (db *DataBase) ThrottleWait(p ThrottlePolicy, keys ...string) (time.Duration, error) {
+	now := p.now()
+	var wait time.Duration
+	for _, key := range keys {
+		e, err := loadThrottleEntry(db.Conn, key)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, p.wait(e, now))
+	}
+	return wait, nil
+}
+
+// RecordFailure counts a failed attempt against every key, locking a key
+// out once it reaches the policy's LockoutAfter.
+func (db *DataBase) RecordFailure(p ThrottlePolicy, keys ...string) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := p.now()
+	for _, key := range keys {
+		e, err := loadThrottleEntry(tx, key)
+		if err != nil {
+			return err
+		}
+		e = p.fail(e, now)
+
+		var lockedUntil interface{}
+		if !e.lockedUntil.IsZero() {
+			lockedUntil = sessionTime(e.lockedUntil)
+		}
+		_, err = tx.Exec(`
+            INSERT INTO auth_throttle (key, failures, last_failure, locked_until) VALUES (?, ?, ?, ?)
+            ON CONFLICT(key) DO UPDATE SET failures = excluded.failures, last_failure = excluded.last_failure,
+                locked_until = excluded.locked_until
+        `, key, e.failures, sessionTime(e.lastFailure), lockedUntil)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearFailures forgets the failures recorded against keys, e.g. after a successful login.
+func (db *DataBase) ClearFailures(keys ...string) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	for _, key := range keys {
+		if _, err := db.Conn.Exec("DELETE FROM auth_throttle WHERE key = ?", key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderTooManyRequests shows the 429 page with a Retry-After header.
+func RenderTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	RenderError(w, fmt.Sprintf("Too many failed attempts. Please try again in %s.", formatWait(seconds)), http.StatusTooManyRequests)
+}
+
+// formatWait renders a wait like "45 seconds" or "3 minutes".
+func formatWait(seconds int) string {
+	if seconds < 60 {
+		return plural(seconds, "second")
+	}
+	return plural(int(math.Ceil(float64(seconds)/60)), "minute")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeClock is a ThrottlePolicy clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// testPolicy is AuthThrottle on a fake clock.
+func testPolicy() (ThrottlePolicy, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+	p := AuthThrottle
+	p.Now = clock.Now
+	return p, clock
+}
+
+func TestThrottleDelay(t *testing.T) {
+	p := AuthThrottle
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{p.FreeFailures, 0},
+		{p.FreeFailures + 1, p.BaseDelay},
+		{p.FreeFailures + 2, 2 * p.BaseDelay},
+		{p.FreeFailures + 3, 4 * p.BaseDelay},
+		{p.FreeFailures + 8, 128 * p.BaseDelay},
+		{p.FreeFailures + 9, p.MaxDelay},
+		{p.FreeFailures + 200, p.MaxDelay},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestThrottleStore(t *testing.T) {
+	p, clock := testPolicy()
+	s := NewMemoryStore()
+	const key = "login:account:alice"
+
+	wait := func() time.Duration {
+		t.Helper()
+		d, err := s.ThrottleWait(p, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	fail := func() {
+		t.Helper()
+		if err := s.RecordFailure(p, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The free failures don't slow anyone down
+	for i := 0; i < p.FreeFailures; i++ {
+		fail()
+		if d := wait(); d != 0 {
+			t.Fatalf("after %d failures: wait %v, want 0", i+1, d)
+		}
+	}
+
+	// Past the threshold each failure doubles the wait
+	for i, want := range []time.Duration{p.BaseDelay, 2 * p.BaseDelay, 4 * p.BaseDelay} {
+		fail()
+		if d := wait(); d != want {
+			t.Fatalf("failure %d past the threshold: wait %v, want %v", i+1, d, want)
+		}
+		clock.Advance(want / 2)
+		if d := wait(); d != want/2 {
+			t.Fatalf("halfway through: wait %v, want %v", d, want/2)
+		}
+		clock.Advance(want / 2)
+		if d := wait(); d != 0 {
+			t.Fatalf("once the delay is over: wait %v, want 0", d)
+		}
+	}
+
+	// Enough failures lock the key out
+	for i := p.FreeFailures + 3; i < p.LockoutAfter; i++ {
+		fail()
+	}
+	if d := wait(); d != p.LockoutDuration {
+		t.Fatalf("after %d failures: wait %v, want the lockout of %v", p.LockoutAfter, d, p.LockoutDuration)
+	}
+	clock.Advance(p.LockoutDuration)
+	if d := wait(); d != 0 {
+		t.Fatalf("after the lockout: wait %v, want 0", d)
+	}
+
+	// Failures older than the window are forgotten
+	for i := 0; i <= p.FreeFailures; i++ {
+		fail()
+	}
+	if d := wait(); d == 0 {
+		t.Fatal("want a wait after passing the threshold again")
+	}
+	clock.Advance(p.Window + time.Second)
+	fail()
+	if d := wait(); d != 0 {
+		t.Fatalf("first failure after the window: wait %v, want 0", d)
+	}
+
+	if err := s.ClearFailures(key); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < p.FreeFailures; i++ {
+		fail()
+	}
+	if d := wait(); d != 0 {
+		t.Fatalf("after clearing: wait %v, want 0", d)
+	}
+}
+
+func TestLoginThrottleKeys(t *testing.T) {
+	h, _ := newMemoryServer(t)
+	p, clock := testPolicy()
+	old := AuthThrottle
+	AuthThrottle = p
+	t.Cleanup(func() { AuthThrottle = old })
+
+	newTestUser(t, h, "alice", "correct horse 1")
+	newTestUser(t, h, "bob", "correct horse 2")
+
+	from := func(addr string) *testClient { return &testClient{t: t, handler: h, addr: addr} }
+	attempt := func(c *testClient, username, password string) int {
+		t.Helper()
+		return c.post("/login", url.Values{"username": {username}, "password": {password}}).Code
+	}
+
+	// An attacker at one address guessing alice's password
+	attacker := from("192.0.2.1:4000")
+	for i := 0; i < AuthThrottle.FreeFailures; i++ {
+		if code := attempt(attacker, "alice", "guess"); code != http.StatusBadRequest {
+			t.Fatalf("failure %d: status %d, want 400", i+1, code)
+		}
+	}
+	if code := attempt(attacker, "alice", "guess"); code != http.StatusBadRequest {
+		t.Fatalf("failure past the threshold: status %d, want 400", code)
+	}
+	rec := from("192.0.2.1:4000").post("/login", url.Values{"username": {"alice"}, "password": {"guess"}})
+	wantStatus(t, rec, http.StatusTooManyRequests)
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+
+	// The address is throttled for every account, and the account from every address
+	if code := attempt(attacker, "bob", "correct horse 2"); code != http.StatusTooManyRequests {
+		t.Errorf("other account from the throttled address: status %d, want 429", code)
+	}
+	if code := attempt(from("198.51.100.7:4000"), "alice", "correct horse 1"); code != http.StatusTooManyRequests {
+		t.Errorf("throttled account from another address: status %d, want 429", code)
+	}
+	if code := attempt(from("198.51.100.7:4000"), "bob", "correct horse 2"); code != http.StatusSeeOther {
+		t.Errorf("other account from another address: status %d, want 303", code)
+	}
+
+	// Once the delay passes alice gets in, which forgets the failures
+	// against her account but not those from the attacker's address
+	clock.Advance(AuthThrottle.BaseDelay)
+	if code := attempt(from("198.51.100.7:4000"), "alice", "correct horse 1"); code != http.StatusSeeOther {
+		t.Fatalf("login after the delay: status %d, want 303", code)
+	}
+	for i := 0; i < AuthThrottle.FreeFailures; i++ {
+		if code := attempt(from("203.0.113.9:4000"), "alice", "guess"); code != http.StatusBadRequest {
+			t.Fatalf("fresh failure %d against alice: status %d, want 400", i+1, code)
+		}
+	}
+	if code := attempt(attacker, "bob", "guess"); code != http.StatusBadRequest {
+		t.Fatalf("attacker after the delay: status %d, want 400", code)
+	}
+	if code := attempt(attacker, "bob", "guess"); code != http.StatusTooManyRequests {
+		t.Errorf("attacker's address should still be throttled: status %d, want 429", code)
+	}
+}
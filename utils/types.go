@@ -15,44 +15,95 @@ type DataBase struct {
 	Write sync.Mutex
 }
 
+// User mirrors a row of the users table; SafeWriter maps its fields to columns.
+// Only the public profile is serialized to JSON.
 type User struct {
-	NotRegistered bool
-	ID            int
-	Username      string
-	Email         string
-	Password      string
-	UUID          string
-	Lastseen      time.Time
-	LoggedIn      bool
+	NotRegistered bool      `json:"guest,omitempty"`
+	ID            int       `json:"-"`
+	Username      string    `json:"username"`
+	Email         string    `json:"-"`
+	Password      string    `json:"-"`
+	UUID          string    `json:"-"`
+	Lastseen      time.Time `json:"-"`
+	EmailVerified bool      `json:"-"`
+}
+
+// Session is a row of the sessions table. Token is only known right after
+// the session is created; the table keeps just its hash.
+type Session struct {
+	ID        int
+	Token     string
+	UserUUID  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	IP        string
+	UserAgent string
+	CSRFToken string
 }
 
 type Post struct {
-	ID       int
-	Title    string
-	Content  string
-	Author   User
-	Comments []Comment
-	Likes    []Interaction
-	DisLikes []Interaction
+	ID           int           `json:"id"`
+	Title        string        `json:"title"`
+	Content      string        `json:"content"`
+	Author       User          `json:"author"`
+	Categories   []Category    `json:"categories"`
+	Comments     []Comment     `json:"comments,omitempty"`
+	Likes        []Interaction `json:"-"`
+	DisLikes     []Interaction `json:"-"`
+	CommentCount int           `json:"comment_count"`
+	LikeCount    int           `json:"like_count"`
+	DislikeCount int           `json:"dislike_count"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	ActiveAt     time.Time     `json:"active_at"` // latest of CreatedAt and its comments' and replies'
+	EditedAt     *time.Time    `json:"edited_at,omitempty"`
+	Deleted      bool          `json:"deleted,omitempty"` // Title and Content are then "[deleted]"
 }
 
 type Comment struct {
-	ID      int
-	Content string
-	Author  User
-	Post    Post
+	ID           int                  `json:"id"`
+	Content      string               `json:"content"`
+	Author       User                 `json:"author"`
+	Post         Post                 `json:"-"`
+	Likes        []CommentInteraction `json:"-"`
+	DisLikes     []CommentInteraction `json:"-"`
+	LikeCount    int                  `json:"like_count"`
+	DislikeCount int                  `json:"dislike_count"`
+	Replies      []Reply              `json:"replies,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+	EditedAt     *time.Time           `json:"edited_at,omitempty"`
+	Deleted      bool                 `json:"deleted,omitempty"` // Content is then "[deleted]"
 }
 
 type Reply struct {
-	ID      int
-	Content string
-	Author  User
-	Comment Comment
+	ID        int       `json:"id"`
+	Content   string    `json:"content"`
+	Author    User      `json:"author"`
+	Comment   Comment   `json:"-"`
+	ParentID  int       `json:"parent_id,omitempty"` // 0 when replying directly to the comment
+	Depth     int       `json:"depth"`
+	Replies   []Reply   `json:"replies,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	CanReply  bool      `json:"-"` // false once Depth reaches MaxReplyDepth
+}
+
+// Revision is the version of a post or comment from before an edit or deletion.
+type Revision struct {
+	ID        int
+	Kind      string // RevisionPost or RevisionComment
+	TargetID  int
+	Title     string // posts only
+	Content   string
+	Editor    User
+	Author    string // UUID of whoever wrote the post or comment
+	Reason    string // RevisionEdit or RevisionDelete
+	CreatedAt time.Time
 }
 
 type Category struct {
-	ID   int
-	Name string
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name"`
 }
 
 type Interaction struct {
@@ -63,6 +114,91 @@ type Interaction struct {
 	DisLike bool
 }
 
+type CommentInteraction struct {
+	ID      int
+	User    User
+	Comment Comment
+	Like    bool
+	DisLike bool
+}
+
+// VoteKind is the state of a user's vote on a post or comment.
+type VoteKind int
+
+const (
+	VoteNone VoteKind = iota
+	VoteLike
+	VoteDislike
+)
+
+// Verb returns the action word used in user-facing messages.
+func (v VoteKind) Verb() string {
+	switch v {
+	case VoteLike:
+		return "like"
+	case VoteDislike:
+		return "dislike"
+	}
+	return "vote on"
+}
+
+// String returns the vote as used in the JSON API: "none", "like" or "dislike".
+func (v VoteKind) String() string {
+	if v == VoteNone {
+		return "none"
+	}
+	return v.Verb()
+}
+
+// PostSort is the order of a post listing, as named in ?sort=.
+type PostSort string
+
+const (
+	SortNew           PostSort = "new"           // newest first
+	SortTop           PostSort = "top"           // most likes net of dislikes
+	SortControversial PostSort = "controversial" // most votes on the losing side
+	SortActive        PostSort = "active"        // latest comment or reply
+)
+
+// PostSorts lists the sort orders in the order they are offered.
+var PostSorts = []PostSort{SortNew, SortTop, SortControversial, SortActive}
+
+// ParseSort returns the sort order named s, where empty means SortNew.
+// It returns SortNew and false if s names no sort order.
+func ParseSort(s string) (PostSort, bool) {
+	if s == "" {
+		return SortNew, true
+	}
+	for _, known := range PostSorts {
+		if string(known) == s {
+			return known, true
+		}
+	}
+	return SortNew, false
+}
+
+// Label returns the name shown on the sort links.
+func (s PostSort) Label() string {
+	switch s {
+	case SortTop:
+		return "Top"
+	case SortControversial:
+		return "Controversial"
+	case SortActive:
+		return "Active"
+	}
+	return "New"
+}
+
+// PostQuery narrows down a post listing; zero fields are ignored and a zero
+// Sort means SortNew.
+type PostQuery struct {
+	Category   string
+	AuthorUUID string
+	LikedBy    string
+	Sort       PostSort
+}
+
 type Filter struct {
 	ID         int
 	User       User
@@ -80,6 +216,12 @@ type SubForum struct {
 	Admins  []User
 }
 
+// SubForumPost is a post as listed in a sub-forum.
+type SubForumPost struct {
+	Post
+	Pinned bool
+}
+
 type HomeData struct {
 	UserLoggedIn bool
 	Username     string
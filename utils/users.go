@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const userColumns = "uuid, username, email, password, notregistered, lastseen, emailverified"
+
+var (
+	// ErrUserExists is returned when a username or email is already taken.
+	ErrUserExists = errors.New("user with this username or email already exists")
+	// ErrUsernameTaken and ErrEmailTaken say which one; both match ErrUserExists.
+	ErrUsernameTaken = fmt.Errorf("%w: username taken", ErrUserExists)
+	ErrEmailTaken    = fmt.Errorf("%w: email taken", ErrUserExists)
+	// ErrNotGuest is returned when a guest-only operation meets a registered or missing user.
+	ErrNotGuest = errors.New("not a guest account")
+)
+
+// scanUser reads one row selected with userColumns.
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	var lastseen string
+	err := row.Scan(&user.UUID, &user.Username, &user.Email, &user.Password, &user.NotRegistered, &lastseen, &user.EmailVerified)
+	if err != nil {
+		return nil, err
+	}
+	user.Lastseen, _ = parseTimestamp(lastseen)
+	return &user, nil
+}
+
+// UserByUUID loads a user by their UUID.
+func (db *DataBase) UserByUUID(uuid string) (*User, error) {
+	return scanUser(db.Conn.QueryRow("SELECT "+userColumns+" FROM users WHERE uuid = ?", uuid))
+}
+
+// FindUser loads the user matching either the username or the email,
+// ignoring case and surrounding spaces. Empty values never match.
+func (db *DataBase) FindUser(username, email string) (*User, error) {
+	return scanUser(db.Conn.QueryRow(`
+        SELECT `+userColumns+` FROM users
+        WHERE (? <> '' AND lower(trim(username)) = lower(trim(?)))
+           OR (? <> '' AND lower(trim(email)) = lower(trim(?)) AND trim(email) <> '')
+    `, username, username, email, email))
+}
+
+// CreateUser inserts a new user row. The unique indexes decide whether the
+// username or email is taken, which reports ErrUsernameTaken or ErrEmailTaken.
+func (db *DataBase) CreateUser(user User) error {
+	return userConflict(db.SafeWriter("users", user))
+}
+
+// UpgradeGuest turns the guest with the given UUID into a registered member in
+// place. Returns ErrUsernameTaken or ErrEmailTaken on a clash, and ErrNotGuest
+// if the UUID isn't a guest (any more).
+func (db *DataBase) UpgradeGuest(uuid, username, email, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	now := time.Now()
+	res, err := db.Conn.Exec(`
+        UPDATE users SET username = ?, email = ?, password = ?, notregistered = 0, emailverified = 0, lastseen = ?
+        WHERE uuid = ? AND notregistered = 1
+    `, username, email, hash, sessionTime(now), uuid)
+	if err != nil {
+		if err := userConflict(err); errors.Is(err, ErrUserExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to upgrade guest: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotGuest
+	}
+
+	return &User{
+		UUID:     uuid,
+		Username: username,
+		Email:    email,
+		Password: hash,
+		Lastseen: now,
+	}, nil
+}
+
+// MarkEmailVerified records that the user confirmed their email address.
+func (db *DataBase) MarkEmailVerified(uuid string) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	_, err := db.Conn.Exec("UPDATE users SET emailverified = 1 WHERE uuid = ?", uuid)
+	return err
+}
+
+// UpdateUser saves the user's username, email, password and verification
+// state. A new email cancels any password reset links sent to the old one.
+func (db *DataBase) UpdateUser(user User) error {
+	db.Write.Lock()
+	defer db.Write.Unlock()
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE password_resets SET used_at = ?
+        WHERE user_uuid = ? AND used_at IS NULL
+          AND EXISTS (SELECT 1 FROM users WHERE uuid = ? AND lower(trim(email)) <> lower(trim(?)))
+    `, sessionTime(time.Now()), user.UUID, user.UUID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("UPDATE users SET username = ?, email = ?, password = ?, emailverified = ? WHERE uuid = ?",
+		user.Username, user.Email, user.Password, user.EmailVerified, user.UUID)
+	if err != nil {
+		return userConflict(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// userConflict turns a unique index violation on users into ErrUsernameTaken
+// or ErrEmailTaken, and returns other errors unchanged.
+func userConflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		switch {
+		case strings.Contains(sqliteErr.Error(), "idx_users_username_ci"):
+			return ErrUsernameTaken
+		case strings.Contains(sqliteErr.Error(), "idx_users_email_ci"):
+			return ErrEmailTaken
+		}
+	}
+	return err
+}
+
+// checkUserCollisions stops the unique-users migration when existing accounts
+// differ only by case or spacing, listing them so they can be fixed by hand.
+func checkUserCollisions(tx *sql.Tx) error {
+	var report []string
+	for _, column := range []string{"username", "email"} {
+		rows, err := tx.Query(fmt.Sprintf(`
+            SELECT lower(trim(%[1]s)), group_concat(%[1]s || ' (' || uuid || ')', ', ')
+            FROM users
+            WHERE trim(%[1]s) <> ''
+            GROUP BY lower(trim(%[1]s))
+            HAVING COUNT(*) > 1
+        `, column))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key, accounts string
+			if err := rows.Scan(&key, &accounts); err != nil {
+				rows.Close()
+				return err
+			}
+			report = append(report, fmt.Sprintf("%s %q: %s", column, key, accounts))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(report) > 0 {
+		return fmt.Errorf("%d username/email collision(s) must be resolved before usernames and emails can be made unique:\n  %s",
+			len(report), strings.Join(report, "\n  "))
+	}
+	return nil
+}
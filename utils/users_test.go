@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUserConflicts(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+
+		tests := []struct {
+			name            string
+			username, email string
+			want            error
+		}{
+			{"same username", "alice", "new@example.com", ErrUsernameTaken},
+			{"username in another case", "ALICE", "new@example.com", ErrUsernameTaken},
+			{"username with spaces", " Alice ", "new@example.com", ErrUsernameTaken},
+			{"email in another case", "carol", "Alice@Example.com", ErrEmailTaken},
+			{"free", "carol", "carol@example.com", nil},
+		}
+		for i, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				user := User{UUID: "new-" + string(rune('a'+i)), Username: tt.username, Email: tt.email}
+				if err := s.CreateUser(user); !errors.Is(err, tt.want) {
+					t.Errorf("CreateUser(%q, %q) = %v, want %v", tt.username, tt.email, err, tt.want)
+				}
+			})
+		}
+
+		// Renaming into someone else's name or email clashes the same way
+		renamed := *bob
+		renamed.Username = "Alice"
+		if err := s.UpdateUser(renamed); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("renaming bob to Alice = %v, want ErrUsernameTaken", err)
+		}
+		renamed = *bob
+		renamed.Email = strings.ToUpper(alice.Email)
+		if err := s.UpdateUser(renamed); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("moving bob to alice's email = %v, want ErrEmailTaken", err)
+		}
+		// but changing the case of your own name doesn't
+		renamed = *bob
+		renamed.Username = "Bob"
+		if err := s.UpdateUser(renamed); err != nil {
+			t.Errorf("renaming bob to Bob = %v", err)
+		}
+	})
+}
+
+// TestUniqueUsersMigration runs migration 9 over accounts that differ only
+// by case, which it must refuse to merge.
+func TestUniqueUsersMigration(t *testing.T) {
+	d := openTestDB(t)
+	if _, err := d.Rollback(MigrationsDir, schemaVersion(t, d)-8); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range [][]string{
+		{"uuid-1", "Bob", "bob@example.com"},
+		{"uuid-2", "bob", "other@example.com"},
+		{"uuid-3", "carol", "BOB@example.com"},
+	} {
+		if _, err := d.Conn.Exec(`INSERT INTO users (uuid, username, email, password, notregistered, lastseen)
+            VALUES (?, ?, ?, '', 0, '')`, u[0], u[1], u[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := d.Migrate(MigrationsDir)
+	if err == nil {
+		t.Fatal("migrating over colliding accounts succeeded")
+	}
+	for _, want := range []string{
+		"0009_unique_users failed",
+		"2 username/email collision(s) must be resolved",
+		`username "bob": `, "Bob (uuid-1)", "bob (uuid-2)",
+		`email "bob@example.com": `, "bob@example.com (uuid-1)", "BOB@example.com (uuid-3)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if v := schemaVersion(t, d); v != 8 {
+		t.Errorf("version %d after the refused migration, want 8", v)
+	}
+
+	// Once they're told apart the migration goes through
+	if _, err := d.Conn.Exec("UPDATE users SET username = 'bobby', email = 'bobby@example.com' WHERE uuid = 'uuid-2'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Conn.Exec("UPDATE users SET email = 'carol@example.com' WHERE uuid = 'uuid-3'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Migrate(MigrationsDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -35,25 +35,37 @@ func (db *DataBase) ForceLogout(w http.ResponseWriter, uuid string) error {
 	return nil
 }
 
+// DeleteUser removes a guest account with its sessions, their audit trail
+// and anything else a guest can leave behind, all or nothing.
 func (db *DataBase) DeleteUser(uuid string) error {
-	query := `
-		DELETE FROM users
-		WHERE uuid = ? AND notregistered = true
-	`
+	db.Write.Lock()
+	defer db.Write.Unlock()
 
-	res, err := db.Conn.Exec(query, uuid)
+	tx, err := db.Conn.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to delete guest user: %w", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := res.RowsAffected()
+	rowsAffected, err := execCount(tx, "DELETE FROM users WHERE uuid = ? AND notregistered = true", uuid)
 	if err != nil {
-		return fmt.Errorf("could not check deletion result: %w", err)
+		return fmt.Errorf("failed to delete guest user: %w", err)
 	}
-
 	if rowsAffected == 0 {
 		return errors.New("no guest user found with the provided UUID")
 	}
 
-	return nil
+	statements := []string{
+		"DELETE FROM interactions WHERE user_uuid = ?",
+		"DELETE FROM comment_interactions WHERE user_uuid = ?",
+		"DELETE FROM session_events WHERE user_uuid = ?",
+		"DELETE FROM sessions WHERE user_uuid = ?",
+		"DELETE FROM password_resets WHERE user_uuid = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, uuid); err != nil {
+			return fmt.Errorf("failed to delete guest data: %w", err)
+		}
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldErrors maps form field names to what is wrong with their value.
+type FieldErrors map[string]string
+
+// Rule checks one value and returns a message describing the problem, or "".
+type Rule func(value string) string
+
+// Validator runs a list of rules per form field. Only the first failing
+// rule of each field is reported.
+type Validator struct {
+	rules map[string][]Rule
+}
+
+// NewValidator returns a Validator with no rules.
+func NewValidator() *Validator {
+	return &Validator{rules: make(map[string][]Rule)}
+}
+
+// Add appends rules for a field and returns v so calls can be chained.
+func (v *Validator) Add(field string, rules ...Rule) *Validator {
+	v.rules[field] = append(v.rules[field], rules...)
+	return v
+}
+
+// Validate checks values, keyed by field name, and returns the problems found.
+func (v *Validator) Validate(values map[string]string) FieldErrors {
+	errs := FieldErrors{}
+	for field, rules := range v.rules {
+		for _, rule := range rules {
+			if msg := rule(values[field]); msg != "" {
+				errs[field] = msg
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// Required rejects empty values.
+func Required(label string) Rule {
+	return func(value string) string {
+		if strings.TrimSpace(value) == "" {
+			return label + " is required"
+		}
+		return ""
+	}
+}
+
+// PasswordPolicy configures the password rules used at sign-up.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int // bcrypt ignores everything past 72 bytes
+	// MinClasses is how many of lowercase, uppercase, digits and symbols must appear.
+	MinClasses int
+	// Banned holds lowercase passwords that are refused outright.
+	Banned map[string]bool
+}
+
+// UsernamePolicy configures the username rules used at sign-up.
+type UsernamePolicy struct {
+	MinLength int
+	MaxLength int
+	// ReservedPrefixes and ReservedNames are compared case-insensitively.
+	ReservedPrefixes []string
+	ReservedNames    []string
+}
+
+//go:embed banned_passwords.txt
+var bannedPasswordsFile string
+
+// DefaultPasswordPolicy is what RegistrationValidator uses.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MaxLength:  72,
+	MinClasses: 3,
+	Banned:     parseWordList(bannedPasswordsFile),
+}
+
+// DefaultUsernamePolicy is what RegistrationValidator uses. "guest_" is
+// taken by guest accounts.
+var DefaultUsernamePolicy = UsernamePolicy{
+	MinLength:        3,
+	MaxLength:        20,
+	ReservedPrefixes: []string{"guest_"},
+	ReservedNames:    []string{"admin", "administrator", "moderator", "root", "system", "anonymous", "deleted"},
+}
+
+// RegistrationValidator checks the sign-up form. Swap it, or Add rules to
+// it, to change what registration accepts.
+var RegistrationValidator = NewRegistrationValidator(DefaultPasswordPolicy, DefaultUsernamePolicy)
+
+// PasswordValidator checks a new password and its confirmation, e.g. on the reset form.
+var PasswordValidator = NewValidator().
+	Add("password", Required("Password"), DefaultPasswordPolicy.Rule()).
+	Add("confirm_password", Required("Password confirmation"))
+
+// UsernameValidator and EmailValidator check the matching /settings forms.
+var (
+	UsernameValidator = NewValidator().Add("username", Required("Username"), DefaultUsernamePolicy.Rule())
+	EmailValidator    = NewValidator().Add("email", Required("Email"), ValidEmail)
+)
+
+// NewRegistrationValidator builds the sign-up rules from the given policies.
+// Matching the confirmation is left to the caller, since rules see one field.
+func NewRegistrationValidator(passwords PasswordPolicy, usernames UsernamePolicy) *Validator {
+	return NewValidator().
+		Add("username", Required("Username"), usernames.Rule()).
+		Add("email", Required("Email"), ValidEmail).
+		Add("password", Required("Password"), passwords.Rule()).
+		Add("confirm_password", Required("Password confirmation"))
+}
+
+// parseWordList reads one lowercase entry per line, skipping blanks and # comments.
+func parseWordList(list string) map[string]bool {
+	words := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words[strings.ToLower(line)] = true
+	}
+	return words
+}
+
+var usernameChars = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// NormalizeUsername trims surrounding whitespace; usernames keep their case for display.
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(username)
+}
+
+// NormalizeEmail trims and lowercases an email address.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Rule returns the username checks for p.
+func (p UsernamePolicy) Rule() Rule {
+	return func(username string) string {
+		username = NormalizeUsername(username)
+		n := utf8.RuneCountInString(username)
+		if n < p.MinLength || n > p.MaxLength {
+			return fmt.Sprintf("Username must be %d to %d characters long", p.MinLength, p.MaxLength)
+		}
+		if !usernameChars.MatchString(username) {
+			return "Username may only contain letters, digits, dots, dashes and underscores"
+		}
+		lower := strings.ToLower(username)
+		for _, prefix := range p.ReservedPrefixes {
+			if strings.HasPrefix(lower, strings.ToLower(prefix)) {
+				return fmt.Sprintf("Usernames starting with %q are reserved", prefix)
+			}
+		}
+		for _, name := range p.ReservedNames {
+			if lower == strings.ToLower(name) {
+				return "That username is reserved"
+			}
+		}
+		return ""
+	}
+}
+
+// ValidEmail accepts a bare address such as name@example.com.
+func ValidEmail(email string) string {
+	email = NormalizeEmail(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "Enter a valid email address"
+	}
+	return ""
+}
+
+// Rule returns the password checks for p.
+func (p PasswordPolicy) Rule() Rule {
+	return func(password string) string {
+		if utf8.RuneCountInString(password) < p.MinLength {
+			return fmt.Sprintf("Password must be at least %d characters long", p.MinLength)
+		}
+		if p.MaxLength > 0 && len(password) > p.MaxLength {
+			return fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength)
+		}
+		if p.Banned[strings.ToLower(password)] {
+			return "That password is too common; pick another"
+		}
+		if classes := characterClasses(password); classes < p.MinClasses {
+			return fmt.Sprintf("Password must mix at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses)
+		}
+		return ""
+	}
+}
+
+// characterClasses counts which of lowercase, uppercase, digits and symbols appear in s.
+func characterClasses(s string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	n := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			n++
+		}
+	}
+	return n
+}
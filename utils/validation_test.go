@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// ruleCase is a value and the start of the message a rule should give it;
+// want "" means the value is accepted.
+type ruleCase struct {
+	name  string
+	value string
+	want  string
+}
+
+func checkRule(t *testing.T, rule Rule, tests []ruleCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rule(tt.value)
+			if tt.want == "" && got != "" {
+				t.Errorf("rule(%q) = %q, want it accepted", tt.value, got)
+			}
+			if tt.want != "" && !strings.HasPrefix(got, tt.want) {
+				t.Errorf("rule(%q) = %q, want %q…", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	const (
+		length   = "Username must be 3 to 20 characters long"
+		chars    = "Username may only contain"
+		reserved = "That username is reserved"
+		prefix   = "Usernames starting with"
+	)
+	checkRule(t, DefaultUsernamePolicy.Rule(), []ruleCase{
+		{"shortest", "abc", ""},
+		{"too short", "ab", length},
+		{"longest", strings.Repeat("a", 20), ""},
+		{"too long", strings.Repeat("a", 21), length},
+		{"surrounding spaces are trimmed", "  abc  ", ""},
+		{"spaces don't count towards the length", "  ab  ", length},
+		{"dots, dashes and underscores", "a.b-c_d", ""},
+		{"keeps its case", "Alice", ""},
+		{"inner space", "al ice", chars},
+		{"accented letter", "jürgen", chars},
+		{"non-Latin script", "алиса", chars},
+		{"length counts runes, not bytes", "ñó", length},
+		{"emoji", "bob🙂", chars},
+		{"reserved name", "admin", reserved},
+		{"reserved name in another case", "ADMIN", reserved},
+		{"reserved name with mixed case", "DeLeTeD", reserved},
+		{"reserved name as a prefix is fine", "admins", ""},
+		{"guest prefix", "guest_bob", prefix},
+		{"guest prefix in another case", "Guest_Bob", prefix},
+		{"guest without the underscore", "guestbob", ""},
+	})
+}
+
+func TestValidateEmail(t *testing.T) {
+	const invalid = "Enter a valid email address"
+	checkRule(t, ValidEmail, []ruleCase{
+		{"plain", "alice@example.com", ""},
+		{"upper case is folded", "Alice@Example.COM", ""},
+		{"surrounding spaces are trimmed", "  alice@example.com ", ""},
+		{"subdomain and plus", "alice+forum@mail.example.co.uk", ""},
+		{"Unicode domain", "alice@exämple.com", ""},
+		{"Unicode local part", "ünï@example.com", ""},
+		{"no dot in the domain", "alice@localhost", invalid},
+		{"no at sign", "alice.example.com", invalid},
+		{"two at signs", "alice@@example.com", invalid},
+		{"display name", "Alice <alice@example.com>", invalid},
+		{"quoted local part", `"al ice"@example.com`, invalid},
+		{"inner space", "al ice@example.com", invalid},
+		{"empty", "", invalid},
+	})
+
+	if got := NormalizeEmail("  Alice@Example.COM "); got != "alice@example.com" {
+		t.Errorf("NormalizeEmail = %q, want alice@example.com", got)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	const (
+		short  = "Password must be at least 8 characters long"
+		long   = "Password must be at most 72 bytes long"
+		banned = "That password is too common"
+		mix    = "Password must mix at least 3 of"
+	)
+	checkRule(t, DefaultPasswordPolicy.Rule(), []ruleCase{
+		{"shortest", "Abcdef1!", ""},
+		{"too short", "Abcde1!", short},
+		{"longest", "Aa1" + strings.Repeat("x", 69), ""},
+		{"too long", "Aa1" + strings.Repeat("x", 70), long},
+		{"two classes", "abcdefgh1", mix},
+		{"one class", "abcdefghij", mix},
+		{"three classes with a space", "correct horse 1", ""},
+		{"length counts runes", "ÄäÖöÜü1", short},
+		{"eight runes is enough", "ÄäÖöÜü12", ""},
+		{"the byte limit counts bytes", "A1" + strings.Repeat("ä", 35), ""},
+		{"over the byte limit in fewer runes", "A1" + strings.Repeat("ä", 36), long},
+		{"emoji count as symbols", "abcdefg1🙂", ""},
+		{"non-Latin letters have case", "Пароль123", ""},
+		{"banned", "password123", banned},
+		{"banned in another case", "PASSWORD123", banned},
+		{"banned with mixed case", "P@ssw0rd", banned},
+		{"banned as part of a longer password is fine", "P@ssw0rd-horse", ""},
+	})
+}
+
+// TestBannedPasswords checks that every entry of banned_passwords.txt long
+// enough to reach the check is refused, in any case.
+func TestBannedPasswords(t *testing.T) {
+	p := DefaultPasswordPolicy
+	if len(p.Banned) == 0 {
+		t.Fatal("no banned passwords loaded")
+	}
+	rule := p.Rule()
+	checked := 0
+	for _, line := range strings.Split(bannedPasswordsFile, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || len(line) < p.MinLength {
+			continue
+		}
+		for _, password := range []string{line, strings.ToUpper(line), strings.ToUpper(line[:1]) + line[1:]} {
+			if got := rule(password); !strings.HasPrefix(got, "That password is too common") {
+				t.Errorf("rule(%q) = %q, want it banned", password, got)
+			}
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("no banned password is long enough to test")
+	}
+}
+
+func TestRegistrationValidator(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   []string // fields with errors
+	}{
+		{
+			name: "valid",
+			values: map[string]string{
+				"username": "alice", "email": "alice@example.com",
+				"password": "correct horse 1", "confirm_password": "correct horse 1",
+			},
+		},
+		{
+			name:   "everything missing",
+			values: map[string]string{},
+			want:   []string{"username", "email", "password", "confirm_password"},
+		},
+		{
+			name: "one bad field",
+			values: map[string]string{
+				"username": "admin", "email": "alice@example.com",
+				"password": "correct horse 1", "confirm_password": "x",
+			},
+			want: []string{"username"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := RegistrationValidator.Validate(tt.values)
+			if len(errs) != len(tt.want) {
+				t.Errorf("errors = %v, want errors on %v", errs, tt.want)
+			}
+			for _, field := range tt.want {
+				if errs[field] == "" {
+					t.Errorf("no error on %s; errors = %v", field, errs)
+				}
+			}
+		})
+	}
+
+	// Required wins over the field's other rules
+	errs := RegistrationValidator.Validate(map[string]string{"password": "   "})
+	if errs["password"] != "Password is required" {
+		t.Errorf("blank password: %q, want %q", errs["password"], "Password is required")
+	}
+}
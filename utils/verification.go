@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// BaseURL is where links in outgoing mail point. It is fixed rather than taken
+// from the request's Host header, which a client controls.
+var BaseURL = "http://localhost:8080"
+
+// VerificationTTL is how long an email verification link stays valid.
+var VerificationTTL = 24 * time.Hour
+
+var (
+	ErrInvalidToken = errors.New("invalid or tampered token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+const purposeVerifyEmail = "verify-email"
+
+// signedClaims is the payload of a signed token.
+type signedClaims struct {
+	Purpose string `json:"p"`
+	UUID    string `json:"u"`
+	Email   string `json:"e"`
+	Expires int64  `json:"x"`
+}
+
+// Secret returns the named server-side key, creating a random one on first use.
+func (db *DataBase) Secret(name string) ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	db.Write.Lock()
+	_, err := db.Conn.Exec("INSERT OR IGNORE INTO secrets (name, value) VALUES (?, ?)", name, hex.EncodeToString(b))
+	db.Write.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var value string
+	if err := db.Conn.QueryRow("SELECT value FROM secrets WHERE name = ?", name).Scan(&value); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(value)
+}
+
+// signToken encodes claims and appends an HMAC so they can't be altered.
+func signToken(claims signedClaims) (string, error) {
+	key, err := store.Secret("token-signing")
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifySignedToken checks a token's signature, purpose and expiry and returns its claims.
+func verifySignedToken(purpose, token string) (*signedClaims, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := store.Secret("token-signing")
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims signedClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// SendVerificationEmail mails the user a link that confirms their address.
+func SendVerificationEmail(user *User) error {
+	token, err := signToken(signedClaims{
+		Purpose: purposeVerifyEmail,
+		UUID:    user.UUID,
+		Email:   user.Email,
+		Expires: time.Now().Add(VerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := BaseURL + "/verify?token=" + url.QueryEscape(token)
+	return Mailer.Send(Mail{
+		To:      user.Email,
+		Subject: "Confirm your ForumHub email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to start posting:\n\n%s\n\nThe link expires in %d hours. If you didn't sign up, ignore this message.\n",
+			user.Username, link, int(VerificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail marks the account behind a verification token as verified.
+// Tokens issued for an address the user has since changed are rejected.
+func VerifyEmail(token string) (*User, error) {
+	claims, err := verifySignedToken(purposeVerifyEmail, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := store.UserByUUID(claims.UUID)
+	if err != nil || !strings.EqualFold(user.Email, claims.Email) {
+		return nil, ErrInvalidToken
+	}
+
+	if err := store.MarkEmailVerified(user.UUID); err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	return user, nil
+}
+
+// VerifyHandler handles GET /verify?token=...
+func VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, err := VerifyEmail(r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		RenderError(w, "This verification link has expired. Log in and request a new one from the home page.", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrInvalidToken):
+		RenderError(w, "This verification link is not valid.", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("Error verifying email:", err)
+		RenderError(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	InitTemplate(w, r, "templates/notice.html", map[string]interface{}{
+		"Title":    "Email verified",
+		"Message":  "Thanks! Your email address is confirmed and you can now post, comment and vote.",
+		"Link":     "/home",
+		"LinkText": "Go to the forum",
+	})
+}
+
+// ResendVerificationHandler handles POST /verify/resend; wrapped in RequireGuestOrUser
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RenderError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := CurrentUser(r)
+	if user.NotRegistered {
+		RenderError(w, "Guests have no email address to verify", http.StatusForbidden)
+		return
+	}
+	if user.EmailVerified {
+		http.Redirect(w, r, "/home", http.StatusSeeOther)
+		return
+	}
+
+	if err := SendVerificationEmail(user); err != nil {
+		log.Println("Error sending verification email:", err)
+		RenderError(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	InitTemplate(w, r, "templates/notice.html", map[string]interface{}{
+		"Title":    "Check your inbox",
+		"Message":  "We sent a new verification link to " + user.Email + ".",
+		"Link":     "/home",
+		"LinkText": "Back to the forum",
+	})
+}